@@ -0,0 +1,204 @@
+package mapper
+
+import "fmt"
+import "image/color"
+
+import "github.com/timocp/nbt"
+
+// A Biome is one of the game's biomes.  Chunks written before 1.18 store
+// them as numeric ids; later ones by name
+type Biome struct {
+	Id   int    // the legacy numeric id; -1 for biomes added since 1.18
+	Name string // namespaced, as of the current version, eg "minecraft:swamp"
+	// names used by earlier versions, eg "minecraft:swampland"
+	Aliases []string
+	// the colour used for the biome on maps
+	Colour color.RGBA
+	// used by the game to choose the colour of grass and leaves
+	Temperature float64
+	Downfall    float64
+}
+
+func rgb(c uint32) color.RGBA {
+	return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 255}
+}
+
+// every biome the game has had.  Map colours are those traditionally used by
+// map tools, going back to the game's own biome debug view
+var Biomes = []Biome{
+	{0, "minecraft:ocean", nil, rgb(0x000070), 0.5, 0.5},
+	{1, "minecraft:plains", nil, rgb(0x8db360), 0.8, 0.4},
+	{2, "minecraft:desert", nil, rgb(0xfa9418), 2.0, 0.0},
+	{3, "minecraft:windswept_hills", []string{"minecraft:extreme_hills", "minecraft:mountains"}, rgb(0x606060), 0.2, 0.3},
+	{4, "minecraft:forest", nil, rgb(0x056621), 0.7, 0.8},
+	{5, "minecraft:taiga", nil, rgb(0x0b6659), 0.25, 0.8},
+	{6, "minecraft:swamp", []string{"minecraft:swampland"}, rgb(0x07f9b2), 0.8, 0.9},
+	{7, "minecraft:river", nil, rgb(0x0000ff), 0.5, 0.5},
+	{8, "minecraft:nether_wastes", []string{"minecraft:hell", "minecraft:nether"}, rgb(0xbf3b3b), 2.0, 0.0},
+	{9, "minecraft:the_end", []string{"minecraft:sky"}, rgb(0x8080ff), 0.5, 0.5},
+	{10, "minecraft:frozen_ocean", nil, rgb(0x7070d6), 0.0, 0.5},
+	{11, "minecraft:frozen_river", nil, rgb(0xa0a0ff), 0.0, 0.5},
+	{12, "minecraft:snowy_plains", []string{"minecraft:ice_flats", "minecraft:snowy_tundra"}, rgb(0xffffff), 0.0, 0.5},
+	{13, "minecraft:snowy_mountains", []string{"minecraft:ice_mountains"}, rgb(0xa0a0a0), 0.0, 0.5},
+	{14, "minecraft:mushroom_fields", []string{"minecraft:mushroom_island"}, rgb(0xff00ff), 0.9, 1.0},
+	{15, "minecraft:mushroom_field_shore", []string{"minecraft:mushroom_island_shore"}, rgb(0xa000ff), 0.9, 1.0},
+	{16, "minecraft:beach", []string{"minecraft:beaches"}, rgb(0xfade55), 0.8, 0.4},
+	{17, "minecraft:desert_hills", nil, rgb(0xd25f12), 2.0, 0.0},
+	{18, "minecraft:wooded_hills", []string{"minecraft:forest_hills"}, rgb(0x22551c), 0.7, 0.8},
+	{19, "minecraft:taiga_hills", nil, rgb(0x163933), 0.25, 0.8},
+	{20, "minecraft:mountain_edge", []string{"minecraft:smaller_extreme_hills"}, rgb(0x72789a), 0.2, 0.3},
+	{21, "minecraft:jungle", nil, rgb(0x537b09), 0.95, 0.9},
+	{22, "minecraft:jungle_hills", nil, rgb(0x2c4205), 0.95, 0.9},
+	{23, "minecraft:sparse_jungle", []string{"minecraft:jungle_edge"}, rgb(0x628b17), 0.95, 0.8},
+	{24, "minecraft:deep_ocean", nil, rgb(0x000030), 0.5, 0.5},
+	{25, "minecraft:stony_shore", []string{"minecraft:stone_beach", "minecraft:stone_shore"}, rgb(0xa2a284), 0.2, 0.3},
+	{26, "minecraft:snowy_beach", []string{"minecraft:cold_beach"}, rgb(0xfaf0c0), 0.05, 0.3},
+	{27, "minecraft:birch_forest", nil, rgb(0x307444), 0.6, 0.6},
+	{28, "minecraft:birch_forest_hills", nil, rgb(0x1f5f32), 0.6, 0.6},
+	{29, "minecraft:dark_forest", []string{"minecraft:roofed_forest"}, rgb(0x40511a), 0.7, 0.8},
+	{30, "minecraft:snowy_taiga", []string{"minecraft:taiga_cold"}, rgb(0x31554a), -0.5, 0.4},
+	{31, "minecraft:snowy_taiga_hills", []string{"minecraft:taiga_cold_hills"}, rgb(0x243f36), -0.5, 0.4},
+	{32, "minecraft:old_growth_pine_taiga", []string{"minecraft:redwood_taiga", "minecraft:giant_tree_taiga"}, rgb(0x596651), 0.3, 0.8},
+	{33, "minecraft:giant_tree_taiga_hills", []string{"minecraft:redwood_taiga_hills"}, rgb(0x454f3e), 0.3, 0.8},
+	{34, "minecraft:windswept_forest", []string{"minecraft:extreme_hills_with_trees", "minecraft:wooded_mountains"}, rgb(0x507050), 0.2, 0.3},
+	{35, "minecraft:savanna", nil, rgb(0xbdb25f), 2.0, 0.0},
+	{36, "minecraft:savanna_plateau", []string{"minecraft:savanna_rock"}, rgb(0xa79d64), 2.0, 0.0},
+	{37, "minecraft:badlands", []string{"minecraft:mesa"}, rgb(0xd94515), 2.0, 0.0},
+	{38, "minecraft:wooded_badlands", []string{"minecraft:mesa_rock", "minecraft:wooded_badlands_plateau"}, rgb(0xb09765), 2.0, 0.0},
+	{39, "minecraft:badlands_plateau", []string{"minecraft:mesa_clear_rock"}, rgb(0xca8c65), 2.0, 0.0},
+	{40, "minecraft:small_end_islands", nil, rgb(0x4b4bab), 0.5, 0.5},
+	{41, "minecraft:end_midlands", nil, rgb(0xc9c959), 0.5, 0.5},
+	{42, "minecraft:end_highlands", nil, rgb(0xb5b536), 0.5, 0.5},
+	{43, "minecraft:end_barrens", nil, rgb(0x7070cc), 0.5, 0.5},
+	{44, "minecraft:warm_ocean", nil, rgb(0x0000ac), 0.5, 0.5},
+	{45, "minecraft:lukewarm_ocean", nil, rgb(0x000090), 0.5, 0.5},
+	{46, "minecraft:cold_ocean", nil, rgb(0x202070), 0.5, 0.5},
+	{47, "minecraft:deep_warm_ocean", nil, rgb(0x000050), 0.5, 0.5},
+	{48, "minecraft:deep_lukewarm_ocean", nil, rgb(0x000040), 0.5, 0.5},
+	{49, "minecraft:deep_cold_ocean", nil, rgb(0x202038), 0.5, 0.5},
+	{50, "minecraft:deep_frozen_ocean", nil, rgb(0x404090), 0.5, 0.5},
+	{127, "minecraft:the_void", []string{"minecraft:void"}, rgb(0x000000), 0.5, 0.5},
+	{129, "minecraft:sunflower_plains", []string{"minecraft:mutated_plains"}, rgb(0xb5db88), 0.8, 0.4},
+	{130, "minecraft:desert_lakes", []string{"minecraft:mutated_desert"}, rgb(0xffbc40), 2.0, 0.0},
+	{131, "minecraft:windswept_gravelly_hills", []string{"minecraft:mutated_extreme_hills", "minecraft:gravelly_mountains"}, rgb(0x888888), 0.2, 0.3},
+	{132, "minecraft:flower_forest", []string{"minecraft:mutated_forest"}, rgb(0x2d8e49), 0.7, 0.8},
+	{133, "minecraft:taiga_mountains", []string{"minecraft:mutated_taiga"}, rgb(0x338e81), 0.25, 0.8},
+	{134, "minecraft:swamp_hills", []string{"minecraft:mutated_swampland"}, rgb(0x2fffda), 0.8, 0.9},
+	{140, "minecraft:ice_spikes", []string{"minecraft:mutated_ice_flats"}, rgb(0xb4dcdc), 0.0, 0.5},
+	{149, "minecraft:modified_jungle", []string{"minecraft:mutated_jungle"}, rgb(0x7ba331), 0.95, 0.9},
+	{151, "minecraft:modified_jungle_edge", []string{"minecraft:mutated_jungle_edge"}, rgb(0x8ab33f), 0.95, 0.8},
+	{155, "minecraft:old_growth_birch_forest", []string{"minecraft:mutated_birch_forest", "minecraft:tall_birch_forest"}, rgb(0x589c6c), 0.6, 0.6},
+	{156, "minecraft:tall_birch_hills", []string{"minecraft:mutated_birch_forest_hills"}, rgb(0x47875a), 0.6, 0.6},
+	{157, "minecraft:dark_forest_hills", []string{"minecraft:mutated_roofed_forest"}, rgb(0x687942), 0.7, 0.8},
+	{158, "minecraft:snowy_taiga_mountains", []string{"minecraft:mutated_taiga_cold"}, rgb(0x597d72), -0.5, 0.4},
+	{160, "minecraft:old_growth_spruce_taiga", []string{"minecraft:mutated_redwood_taiga", "minecraft:giant_spruce_taiga"}, rgb(0x818e79), 0.25, 0.8},
+	{161, "minecraft:giant_spruce_taiga_hills", []string{"minecraft:mutated_redwood_taiga_hills"}, rgb(0x6d7766), 0.25, 0.8},
+	{162, "minecraft:modified_gravelly_mountains", []string{"minecraft:mutated_extreme_hills_with_trees"}, rgb(0x789878), 0.2, 0.3},
+	{163, "minecraft:windswept_savanna", []string{"minecraft:mutated_savanna", "minecraft:shattered_savanna"}, rgb(0xe5da87), 2.0, 0.0},
+	{164, "minecraft:shattered_savanna_plateau", []string{"minecraft:mutated_savanna_rock"}, rgb(0xcfc58c), 1.0, 0.0},
+	{165, "minecraft:eroded_badlands", []string{"minecraft:mutated_mesa"}, rgb(0xff6d3d), 2.0, 0.0},
+	{166, "minecraft:modified_wooded_badlands_plateau", []string{"minecraft:mutated_mesa_rock"}, rgb(0xd8bf8d), 2.0, 0.0},
+	{167, "minecraft:modified_badlands_plateau", []string{"minecraft:mutated_mesa_clear_rock"}, rgb(0xf2b48d), 2.0, 0.0},
+	{168, "minecraft:bamboo_jungle", nil, rgb(0x768e14), 0.95, 0.9},
+	{169, "minecraft:bamboo_jungle_hills", nil, rgb(0x3b470a), 0.95, 0.9},
+	{170, "minecraft:soul_sand_valley", nil, rgb(0x5e3830), 2.0, 0.0},
+	{171, "minecraft:crimson_forest", nil, rgb(0xdd0808), 2.0, 0.0},
+	{172, "minecraft:warped_forest", nil, rgb(0x49907b), 2.0, 0.0},
+	{173, "minecraft:basalt_deltas", nil, rgb(0x403636), 2.0, 0.0},
+	{174, "minecraft:dripstone_caves", nil, rgb(0x4e3012), 0.8, 0.4},
+	{175, "minecraft:lush_caves", nil, rgb(0x283c00), 0.5, 0.5},
+	{-1, "minecraft:meadow", nil, rgb(0x60a445), 0.5, 0.8},
+	{-1, "minecraft:grove", nil, rgb(0x47726c), -0.2, 0.8},
+	{-1, "minecraft:snowy_slopes", nil, rgb(0xc4c4c4), -0.3, 0.9},
+	{-1, "minecraft:jagged_peaks", nil, rgb(0xdcdcc8), -0.7, 0.9},
+	{-1, "minecraft:frozen_peaks", nil, rgb(0xb0b3ce), -0.7, 0.9},
+	{-1, "minecraft:stony_peaks", nil, rgb(0x7b8f74), 1.0, 0.3},
+	{-1, "minecraft:deep_dark", nil, rgb(0x031f29), 0.8, 0.4},
+	{-1, "minecraft:mangrove_swamp", nil, rgb(0x2ccc8e), 0.8, 0.9},
+	{-1, "minecraft:cherry_grove", nil, rgb(0xff91c8), 0.5, 0.8},
+	{-1, "minecraft:pale_garden", nil, rgb(0x696d95), 0.7, 0.8},
+}
+
+var biomesById = make(map[int]*Biome)
+var biomesByName = make(map[string]*Biome)
+
+func init() {
+	for i := range Biomes {
+		b := &Biomes[i]
+		if b.Id >= 0 {
+			biomesById[b.Id] = b
+		}
+		biomesByName[b.Name] = b
+		for _, alias := range b.Aliases {
+			biomesByName[alias] = b
+		}
+	}
+}
+
+// the colour of biomes which aren't in the table
+var unknownBiomeColour = color.RGBA{128, 128, 128, 255}
+
+// returns the biome with a legacy numeric id.  Unknown ids give a biome
+// with only the id set, coloured grey
+func BiomeById(id int) *Biome {
+	if b, ok := biomesById[id]; ok {
+		return b
+	}
+	return &Biome{Id: id, Name: fmt.Sprintf("unknown:%d", id), Colour: unknownBiomeColour, Temperature: 0.5, Downfall: 0.5}
+}
+
+// returns the biome with a namespaced name, current or former.  Unknown
+// names, such as those added by data packs, give a biome with only the
+// name set, coloured grey
+func BiomeByName(name string) *Biome {
+	if b, ok := biomesByName[name]; ok {
+		return b
+	}
+	return &Biome{Id: -1, Name: name, Colour: unknownBiomeColour, Temperature: 0.5, Downfall: 0.5}
+}
+
+// true if b is one of the game's own biomes
+func (b *Biome) Known() bool {
+	return biomesByName[b.Name] == b
+}
+
+// returns the biome at coords x y z, whatever the chunk's format: before
+// 1.15 biomes are the same for a whole column, and then they're stored in
+// 4x4x4 cells, by numeric id until 1.18 and by name after.  Returns nil if
+// the chunk has no biomes there
+func (c *Chunk) Biome(x int, y int, z int) (*Biome, error) {
+	if c.DataVersion >= VersionCubic {
+		name, err := c.BiomeName(x, y, z)
+		if err != nil || name == "" {
+			return nil, err
+		}
+		return BiomeByName(name), nil
+	}
+	level, err := c.Level()
+	if err != nil {
+		return nil, err
+	}
+	t := level.ChildByName("Biomes")
+	switch biomes := t.(type) {
+	case nil:
+		return nil, nil
+	case nbt.ByteArrayTag:
+		// 255 means it hasn't been worked out yet
+		if len(biomes.Values) != 256 || biomes.Values[z*16+x] == 255 {
+			return nil, nil
+		}
+		return BiomeById(int(biomes.Values[z*16+x])), nil
+	case nbt.IntArrayTag:
+		switch len(biomes.Values) {
+		case 256: // 1.13-1.14
+			return BiomeById(int(biomes.Values[z*16+x])), nil
+		case 1024: // 1.15-1.17
+			if y < 0 || y > 255 {
+				return nil, nil
+			}
+			return BiomeById(int(biomes.Values[(y>>2)*16+(z>>2)*4+(x>>2)])), nil
+		}
+		return nil, fmt.Errorf("Biomes has %d values", len(biomes.Values))
+	}
+	return nil, tagTypeError("Biomes", nbt.IntArrayTag{}, t)
+}
@@ -2,10 +2,20 @@ package mapper
 
 import "image/color"
 import "fmt"
+import "sort"
+import "strings"
 
 type Block struct {
 	Id   int16 // composed of "byte" and "add" from a chunk Section
 	Data int8  // actually only 4 bits (0-15)
+	// blocks read from a 1.13+ palette are identified by their namespaced
+	// name and properties instead.  Id is then the nearest legacy id (so
+	// Colour still works), or -1 if there isn't one
+	name string
+	// the properties as "key=value,key=value" sorted by key, rather than a
+	// map, so that Blocks can still be compared with == and used as map
+	// keys
+	properties string
 	// missing: light/skyligh
 }
 
@@ -252,486 +262,618 @@ const (
 // just take the id and the data fields as parameters
 func NewBlock(id int16, data int8) Block {
 	// returns new struct
-	return Block{id, data}
+	return Block{Id: id, Data: data}
 }
 
+// a block from a post-1.13 section palette, eg "minecraft:oak_log" with
+// properties {"axis": "y"}
+func NewNamedBlock(name string, properties map[string]string) Block {
+	id, ok := flattenedIds[name]
+	if !ok {
+		id, ok = legacyIds[name]
+	}
+	if !ok {
+		id = -1
+	}
+	var props []string
+	for k, v := range properties {
+		props = append(props, k+"="+v)
+	}
+	sort.Strings(props)
+	return Block{Id: id, name: name, properties: strings.Join(props, ",")}
+}
+
+// returns the properties of a block from a 1.13+ palette, such as
+// {"axis": "y"}, or nil if it has none.  The map is the caller's to change
+func (b Block) Properties() map[string]string {
+	if b.properties == "" {
+		return nil
+	}
+	props := make(map[string]string)
+	for _, kv := range strings.Split(b.properties, ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		props[k] = v
+	}
+	return props
+}
+
+// returns the value of one of the block's properties, and false if it
+// hasn't that property
+func (b Block) Property(key string) (string, bool) {
+	rest := b.properties
+	for rest != "" {
+		var kv string
+		kv, rest, _ = strings.Cut(rest, ",")
+		if k, v, _ := strings.Cut(kv, "="); k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// returns the namespaced name of the block, such as "minecraft:stone".
+// Legacy ids which aren't known are named "unknown:<id>"; use LookupName to
+// detect them
 func (b Block) Name() string {
+	name, err := b.LookupName()
+	if err != nil {
+		return fmt.Sprintf("unknown:%d", b.Id)
+	}
+	return name
+}
+
+// like Name, but returns an UnknownBlockError for unknown legacy ids
+func (b Block) LookupName() (string, error) {
+	if b.name != "" {
+		return b.name, nil
+	}
 	// giant case statement which returns things like "minecraft:stone"
 	switch b.Id {
 	case Air:
-		return "minecraft:air"
+		return "minecraft:air", nil
 	case Stone:
-		return "minecraft:stone"
+		return "minecraft:stone", nil
 	case Grass:
-		return "minecraft:grass"
+		return "minecraft:grass", nil
 	case Dirt:
-		return "minecraft:dirt"
+		return "minecraft:dirt", nil
 	case Cobblestone:
-		return "minecraft:cobblestone"
+		return "minecraft:cobblestone", nil
 	case Planks:
-		return "minecraft:planks"
+		return "minecraft:planks", nil
 	case Sapling:
-		return "minecraft:sapling"
+		return "minecraft:sapling", nil
 	case Bedrock:
-		return "minecraft:bedrock"
+		return "minecraft:bedrock", nil
 	case Flowing_water:
-		return "minecraft:flowing_water"
+		return "minecraft:flowing_water", nil
 	case Water:
-		return "minecraft:water"
+		return "minecraft:water", nil
 	case Flowing_lava:
-		return "minecraft:flowing_lava"
+		return "minecraft:flowing_lava", nil
 	case Lava:
-		return "minecraft:lava"
+		return "minecraft:lava", nil
 	case Sand:
-		return "minecraft:sand"
+		return "minecraft:sand", nil
 	case Gravel:
-		return "minecraft:gravel"
+		return "minecraft:gravel", nil
 	case Gold_ore:
-		return "minecraft:gold_ore"
+		return "minecraft:gold_ore", nil
 	case Iron_ore:
-		return "minecraft:iron_ore"
+		return "minecraft:iron_ore", nil
 	case Coal_ore:
-		return "minecraft:coal_ore"
+		return "minecraft:coal_ore", nil
 	case Log:
-		return "minecraft:log"
+		return "minecraft:log", nil
 	case Leaves:
-		return "minecraft:leaves"
+		return "minecraft:leaves", nil
 	case Sponge:
-		return "minecraft:sponge"
+		return "minecraft:sponge", nil
 	case Glass:
-		return "minecraft:glass"
+		return "minecraft:glass", nil
 	case Lapis_ore:
-		return "minecraft:lapis_ore"
+		return "minecraft:lapis_ore", nil
 	case Lapis_block:
-		return "minecraft:lapis_block"
+		return "minecraft:lapis_block", nil
 	case Dispenser:
-		return "minecraft:dispenser"
+		return "minecraft:dispenser", nil
 	case Sandstone:
-		return "minecraft:sandstone"
+		return "minecraft:sandstone", nil
 	case Noteblock:
-		return "minecraft:noteblock"
+		return "minecraft:noteblock", nil
 	case Bed:
-		return "minecraft:bed"
+		return "minecraft:bed", nil
 	case Golden_rail:
-		return "minecraft:golden_rail"
+		return "minecraft:golden_rail", nil
 	case Detector_rail:
-		return "minecraft:detector_rail"
+		return "minecraft:detector_rail", nil
 	case Sticky_piston:
-		return "minecraft:sticky_piston"
+		return "minecraft:sticky_piston", nil
 	case Web:
-		return "minecraft:web"
+		return "minecraft:web", nil
 	case Tallgrass:
-		return "minecraft:tallgrass"
+		return "minecraft:tallgrass", nil
 	case Deadbush:
-		return "minecraft:deadbush"
+		return "minecraft:deadbush", nil
 	case Piston:
-		return "minecraft:piston"
+		return "minecraft:piston", nil
 	case Piston_head:
-		return "minecraft:piston_head"
+		return "minecraft:piston_head", nil
 	case Wool:
-		return "minecraft:wool"
+		return "minecraft:wool", nil
 	case Piston_extension:
-		return "minecraft:piston_extension"
+		return "minecraft:piston_extension", nil
 	case Yellow_flower:
-		return "minecraft:yellow_flower"
+		return "minecraft:yellow_flower", nil
 	case Red_flower:
-		return "minecraft:red_flower"
+		return "minecraft:red_flower", nil
 	case Brown_mushroom:
-		return "minecraft:brown_mushroom"
+		return "minecraft:brown_mushroom", nil
 	case Red_mushroom:
-		return "minecraft:red_mushroom"
+		return "minecraft:red_mushroom", nil
 	case Gold_block:
-		return "minecraft:gold_block"
+		return "minecraft:gold_block", nil
 	case Iron_block:
-		return "minecraft:iron_block"
+		return "minecraft:iron_block", nil
 	case Double_stone_slab:
-		return "minecraft:double_stone_slab"
+		return "minecraft:double_stone_slab", nil
 	case Stone_slab:
-		return "minecraft:stone_slab"
+		return "minecraft:stone_slab", nil
 	case Brick_block:
-		return "minecraft:brick_block"
+		return "minecraft:brick_block", nil
 	case Tnt:
-		return "minecraft:tnt"
+		return "minecraft:tnt", nil
 	case Bookshelf:
-		return "minecraft:bookshelf"
+		return "minecraft:bookshelf", nil
 	case Mossy_cobblestone:
-		return "minecraft:mossy_cobblestone"
+		return "minecraft:mossy_cobblestone", nil
 	case Obsidian:
-		return "minecraft:obsidian"
+		return "minecraft:obsidian", nil
 	case Torch:
-		return "minecraft:torch"
+		return "minecraft:torch", nil
 	case Fire:
-		return "minecraft:fire"
+		return "minecraft:fire", nil
 	case Mob_spawner:
-		return "minecraft:mob_spawner"
+		return "minecraft:mob_spawner", nil
 	case Oak_stairs:
-		return "minecraft:oak_stairs"
+		return "minecraft:oak_stairs", nil
 	case Chest:
-		return "minecraft:chest"
+		return "minecraft:chest", nil
 	case Redstone_wire:
-		return "minecraft:redstone_wire"
+		return "minecraft:redstone_wire", nil
 	case Diamond_ore:
-		return "minecraft:diamond_ore"
+		return "minecraft:diamond_ore", nil
 	case Diamond_block:
-		return "minecraft:diamond_block"
+		return "minecraft:diamond_block", nil
 	case Crafting_table:
-		return "minecraft:crafting_table"
+		return "minecraft:crafting_table", nil
 	case Wheat:
-		return "minecraft:wheat"
+		return "minecraft:wheat", nil
 	case Farmland:
-		return "minecraft:farmland"
+		return "minecraft:farmland", nil
 	case Furnace:
-		return "minecraft:furnace"
+		return "minecraft:furnace", nil
 	case Lit_furnace:
-		return "minecraft:lit_furnace"
+		return "minecraft:lit_furnace", nil
 	case Standing_sign:
-		return "minecraft:standing_sign"
+		return "minecraft:standing_sign", nil
 	case Wooden_door:
-		return "minecraft:wooden_door"
+		return "minecraft:wooden_door", nil
 	case Ladder:
-		return "minecraft:ladder"
+		return "minecraft:ladder", nil
 	case Rail:
-		return "minecraft:rail"
+		return "minecraft:rail", nil
 	case Stone_stairs:
-		return "minecraft:stone_stairs"
+		return "minecraft:stone_stairs", nil
 	case Wall_sign:
-		return "minecraft:wall_sign"
+		return "minecraft:wall_sign", nil
 	case Lever:
-		return "minecraft:lever"
+		return "minecraft:lever", nil
 	case Stone_pressure_plate:
-		return "minecraft:stone_pressure_plate"
+		return "minecraft:stone_pressure_plate", nil
 	case Iron_door:
-		return "minecraft:iron_door"
+		return "minecraft:iron_door", nil
 	case Wooden_pressure_plate:
-		return "minecraft:wooden_pressure_plate"
+		return "minecraft:wooden_pressure_plate", nil
 	case Redstone_ore:
-		return "minecraft:redstone_ore"
+		return "minecraft:redstone_ore", nil
 	case Lit_redstone_ore:
-		return "minecraft:lit_redstone_ore"
+		return "minecraft:lit_redstone_ore", nil
 	case Unlit_redstone_torch:
-		return "minecraft:unlit_redstone_torch"
+		return "minecraft:unlit_redstone_torch", nil
 	case Redstone_torch:
-		return "minecraft:redstone_torch"
+		return "minecraft:redstone_torch", nil
 	case Stone_button:
-		return "minecraft:stone_button"
+		return "minecraft:stone_button", nil
 	case Snow_layer:
-		return "minecraft:snow_layer"
+		return "minecraft:snow_layer", nil
 	case Ice:
-		return "minecraft:ice"
+		return "minecraft:ice", nil
 	case Snow:
-		return "minecraft:snow"
+		return "minecraft:snow", nil
 	case Cactus:
-		return "minecraft:cactus"
+		return "minecraft:cactus", nil
 	case Clay:
-		return "minecraft:clay"
+		return "minecraft:clay", nil
 	case Reeds:
-		return "minecraft:reeds"
+		return "minecraft:reeds", nil
 	case Jukebox:
-		return "minecraft:jukebox"
+		return "minecraft:jukebox", nil
 	case Fence:
-		return "minecraft:fence"
+		return "minecraft:fence", nil
 	case Pumpkin:
-		return "minecraft:pumpkin"
+		return "minecraft:pumpkin", nil
 	case Netherrack:
-		return "minecraft:netherrack"
+		return "minecraft:netherrack", nil
 	case Soul_sand:
-		return "minecraft:soul_sand"
+		return "minecraft:soul_sand", nil
 	case Glowstone:
-		return "minecraft:glowstone"
+		return "minecraft:glowstone", nil
 	case Portal:
-		return "minecraft:portal"
+		return "minecraft:portal", nil
 	case Lit_pumpkin:
-		return "minecraft:lit_pumpkin"
+		return "minecraft:lit_pumpkin", nil
 	case Cake:
-		return "minecraft:cake"
+		return "minecraft:cake", nil
 	case Unpowered_repeater:
-		return "minecraft:unpowered_repeater"
+		return "minecraft:unpowered_repeater", nil
 	case Powered_repeater:
-		return "minecraft:powered_repeater"
+		return "minecraft:powered_repeater", nil
 	case Stained_glass:
-		return "minecraft:stained_glass"
+		return "minecraft:stained_glass", nil
 	case Trapdoor:
-		return "minecraft:trapdoor"
+		return "minecraft:trapdoor", nil
 	case Monster_egg:
-		return "minecraft:monster_egg"
+		return "minecraft:monster_egg", nil
 	case Stonebrick:
-		return "minecraft:stonebrick"
+		return "minecraft:stonebrick", nil
 	case Brown_mushroom_block:
-		return "minecraft:brown_mushroom_block"
+		return "minecraft:brown_mushroom_block", nil
 	case Red_mushroom_block:
-		return "minecraft:red_mushroom_block"
+		return "minecraft:red_mushroom_block", nil
 	case Iron_bars:
-		return "minecraft:iron_bars"
+		return "minecraft:iron_bars", nil
 	case Glass_pane:
-		return "minecraft:glass_pane"
+		return "minecraft:glass_pane", nil
 	case Melon_block:
-		return "minecraft:melon_block"
+		return "minecraft:melon_block", nil
 	case Pumpkin_stem:
-		return "minecraft:pumpkin_stem"
+		return "minecraft:pumpkin_stem", nil
 	case Melon_stem:
-		return "minecraft:melon_stem"
+		return "minecraft:melon_stem", nil
 	case Vine:
-		return "minecraft:vine"
+		return "minecraft:vine", nil
 	case Fence_gate:
-		return "minecraft:fence_gate"
+		return "minecraft:fence_gate", nil
 	case Brick_stairs:
-		return "minecraft:brick_stairs"
+		return "minecraft:brick_stairs", nil
 	case Stone_brick_stairs:
-		return "minecraft:stone_brick_stairs"
+		return "minecraft:stone_brick_stairs", nil
 	case Mycelium:
-		return "minecraft:mycelium"
+		return "minecraft:mycelium", nil
 	case Waterlily:
-		return "minecraft:waterlily"
+		return "minecraft:waterlily", nil
 	case Nether_brick:
-		return "minecraft:nether_brick"
+		return "minecraft:nether_brick", nil
 	case Nether_brick_fence:
-		return "minecraft:nether_brick_fence"
+		return "minecraft:nether_brick_fence", nil
 	case Nether_brick_stairs:
-		return "minecraft:nether_brick_stairs"
+		return "minecraft:nether_brick_stairs", nil
 	case Nether_wart:
-		return "minecraft:nether_wart"
+		return "minecraft:nether_wart", nil
 	case Enchanting_table:
-		return "minecraft:enchanting_table"
+		return "minecraft:enchanting_table", nil
 	case Brewing_stand:
-		return "minecraft:brewing_stand"
+		return "minecraft:brewing_stand", nil
 	case Cauldron:
-		return "minecraft:cauldron"
+		return "minecraft:cauldron", nil
 	case End_portal:
-		return "minecraft:end_portal"
+		return "minecraft:end_portal", nil
 	case End_portal_frame:
-		return "minecraft:end_portal_frame"
+		return "minecraft:end_portal_frame", nil
 	case End_stone:
-		return "minecraft:end_stone"
+		return "minecraft:end_stone", nil
 	case Dragon_egg:
-		return "minecraft:dragon_egg"
+		return "minecraft:dragon_egg", nil
 	case Redstone_lamp:
-		return "minecraft:redstone_lamp"
+		return "minecraft:redstone_lamp", nil
 	case Lit_redstone_lamp:
-		return "minecraft:lit_redstone_lamp"
+		return "minecraft:lit_redstone_lamp", nil
 	case Double_wooden_slab:
-		return "minecraft:double_wooden_slab"
+		return "minecraft:double_wooden_slab", nil
 	case Wooden_slab:
-		return "minecraft:wooden_slab"
+		return "minecraft:wooden_slab", nil
 	case Cocoa:
-		return "minecraft:cocoa"
+		return "minecraft:cocoa", nil
 	case Sandstone_stairs:
-		return "minecraft:sandstone_stairs"
+		return "minecraft:sandstone_stairs", nil
 	case Emerald_ore:
-		return "minecraft:emerald_ore"
+		return "minecraft:emerald_ore", nil
 	case Ender_chest:
-		return "minecraft:ender_chest"
+		return "minecraft:ender_chest", nil
 	case Tripwire_hook:
-		return "minecraft:tripwire_hook"
+		return "minecraft:tripwire_hook", nil
 	case Tripwire:
-		return "minecraft:tripwire"
+		return "minecraft:tripwire", nil
 	case Emerald_block:
-		return "minecraft:emerald_block"
+		return "minecraft:emerald_block", nil
 	case Spruce_stairs:
-		return "minecraft:spruce_stairs"
+		return "minecraft:spruce_stairs", nil
 	case Birch_stairs:
-		return "minecraft:birch_stairs"
+		return "minecraft:birch_stairs", nil
 	case Jungle_stairs:
-		return "minecraft:jungle_stairs"
+		return "minecraft:jungle_stairs", nil
 	case Command_block:
-		return "minecraft:command_block"
+		return "minecraft:command_block", nil
 	case Beacon:
-		return "minecraft:beacon"
+		return "minecraft:beacon", nil
 	case Cobblestone_wall:
-		return "minecraft:cobblestone_wall"
+		return "minecraft:cobblestone_wall", nil
 	case Flower_pot:
-		return "minecraft:flower_pot"
+		return "minecraft:flower_pot", nil
 	case Carrots:
-		return "minecraft:carrots"
+		return "minecraft:carrots", nil
 	case Potatoes:
-		return "minecraft:potatoes"
+		return "minecraft:potatoes", nil
 	case Wooden_button:
-		return "minecraft:wooden_button"
+		return "minecraft:wooden_button", nil
 	case Skull:
-		return "minecraft:skull"
+		return "minecraft:skull", nil
 	case Anvil:
-		return "minecraft:anvil"
+		return "minecraft:anvil", nil
 	case Trapped_chest:
-		return "minecraft:trapped_chest"
+		return "minecraft:trapped_chest", nil
 	case Light_weighted_pressure_plate:
-		return "minecraft:light_weighted_pressure_plate"
+		return "minecraft:light_weighted_pressure_plate", nil
 	case Heavy_weighted_pressure_plate:
-		return "minecraft:heavy_weighted_pressure_plate"
+		return "minecraft:heavy_weighted_pressure_plate", nil
 	case Unpowered_comparator:
-		return "minecraft:unpowered_comparator"
+		return "minecraft:unpowered_comparator", nil
 	case Powered_comparator:
-		return "minecraft:powered_comparator"
+		return "minecraft:powered_comparator", nil
 	case Daylight_detector:
-		return "minecraft:daylight_detector"
+		return "minecraft:daylight_detector", nil
 	case Redstone_block:
-		return "minecraft:redstone_block"
+		return "minecraft:redstone_block", nil
 	case Quartz_ore:
-		return "minecraft:quartz_ore"
+		return "minecraft:quartz_ore", nil
 	case Hopper:
-		return "minecraft:hopper"
+		return "minecraft:hopper", nil
 	case Quartz_block:
-		return "minecraft:quartz_block"
+		return "minecraft:quartz_block", nil
 	case Quartz_stairs:
-		return "minecraft:quartz_stairs"
+		return "minecraft:quartz_stairs", nil
 	case Activator_rail:
-		return "minecraft:activator_rail"
+		return "minecraft:activator_rail", nil
 	case Dropper:
-		return "minecraft:dropper"
+		return "minecraft:dropper", nil
 	case Stained_hardened_clay:
-		return "minecraft:stained_hardened_clay"
+		return "minecraft:stained_hardened_clay", nil
 	case Stained_glass_pane:
-		return "minecraft:stained_glass_pane"
+		return "minecraft:stained_glass_pane", nil
 	case Leaves2:
-		return "minecraft:leaves2"
+		return "minecraft:leaves2", nil
 	case Log2:
-		return "minecraft:log2"
+		return "minecraft:log2", nil
 	case Acacia_stairs:
-		return "minecraft:acacia_stairs"
+		return "minecraft:acacia_stairs", nil
 	case Dark_oak_stairs:
-		return "minecraft:dark_oak_stairs"
+		return "minecraft:dark_oak_stairs", nil
 	case Slime:
-		return "minecraft:slime"
+		return "minecraft:slime", nil
 	case Barrier:
-		return "minecraft:barrier"
+		return "minecraft:barrier", nil
 	case Iron_trapdoor:
-		return "minecraft:iron_trapdoor"
+		return "minecraft:iron_trapdoor", nil
 	case Prismarine:
-		return "minecraft:prismarine"
+		return "minecraft:prismarine", nil
 	case Sea_lantern:
-		return "minecraft:sea_lantern"
+		return "minecraft:sea_lantern", nil
 	case Hay_block:
-		return "minecraft:hay_block"
+		return "minecraft:hay_block", nil
 	case Carpet:
-		return "minecraft:carpet"
+		return "minecraft:carpet", nil
 	case Hardened_clay:
-		return "minecraft:hardened_clay"
+		return "minecraft:hardened_clay", nil
 	case Coal_block:
-		return "minecraft:coal_block"
+		return "minecraft:coal_block", nil
 	case Packed_ice:
-		return "minecraft:packed_ice"
+		return "minecraft:packed_ice", nil
 	case Double_plant:
-		return "minecraft:double_plant"
+		return "minecraft:double_plant", nil
 	case Standing_banner:
-		return "minecraft:standing_banner"
+		return "minecraft:standing_banner", nil
 	case Wall_banner:
-		return "minecraft:wall_banner"
+		return "minecraft:wall_banner", nil
 	case Daylight_detector_inverted:
-		return "minecraft:daylight_detector_inverted"
+		return "minecraft:daylight_detector_inverted", nil
 	case Red_sandstone:
-		return "minecraft:red_sandstone"
+		return "minecraft:red_sandstone", nil
 	case Red_sandstone_stairs:
-		return "minecraft:red_sandstone_stairs"
+		return "minecraft:red_sandstone_stairs", nil
 	case Double_stone_slab2:
-		return "minecraft:double_stone_slab2"
+		return "minecraft:double_stone_slab2", nil
 	case Stone_slab2:
-		return "minecraft:stone_slab2"
+		return "minecraft:stone_slab2", nil
 	case Spruce_fence_gate:
-		return "minecraft:spruce_fence_gate"
+		return "minecraft:spruce_fence_gate", nil
 	case Birch_fence_gate:
-		return "minecraft:birch_fence_gate"
+		return "minecraft:birch_fence_gate", nil
 	case Jungle_fence_gate:
-		return "minecraft:jungle_fence_gate"
+		return "minecraft:jungle_fence_gate", nil
 	case Dark_oak_fence_gate:
-		return "minecraft:dark_oak_fence_gate"
+		return "minecraft:dark_oak_fence_gate", nil
 	case Acacia_fence_gate:
-		return "minecraft:acacia_fence_gate"
+		return "minecraft:acacia_fence_gate", nil
 	case Spruce_fence:
-		return "minecraft:spruce_fence"
+		return "minecraft:spruce_fence", nil
 	case Birch_fence:
-		return "minecraft:birch_fence"
+		return "minecraft:birch_fence", nil
 	case Jungle_fence:
-		return "minecraft:jungle_fence"
+		return "minecraft:jungle_fence", nil
 	case Dark_oak_fence:
-		return "minecraft:dark_oak_fence"
+		return "minecraft:dark_oak_fence", nil
 	case Acacia_fence:
-		return "minecraft:acacia_fence"
+		return "minecraft:acacia_fence", nil
 	case Spruce_door:
-		return "minecraft:spruce_door"
+		return "minecraft:spruce_door", nil
 	case Birch_door:
-		return "minecraft:birch_door"
+		return "minecraft:birch_door", nil
 	case Jungle_door:
-		return "minecraft:jungle_door"
+		return "minecraft:jungle_door", nil
 	case Acacia_door:
-		return "minecraft:acacia_door"
+		return "minecraft:acacia_door", nil
 	case Dark_oak_door:
-		return "minecraft:dark_oak_door"
+		return "minecraft:dark_oak_door", nil
 	case End_rod:
-		return "minecraft:end_rod"
+		return "minecraft:end_rod", nil
 	case Chorus_plant:
-		return "minecraft:chorus_plant"
+		return "minecraft:chorus_plant", nil
 	case Chorus_flower:
-		return "minecraft:chorus_flower"
+		return "minecraft:chorus_flower", nil
 	case Purpur_block:
-		return "minecraft:purpur_block"
+		return "minecraft:purpur_block", nil
 	case Purpur_pillar:
-		return "minecraft:purpur_pillar"
+		return "minecraft:purpur_pillar", nil
 	case Purpur_stairs:
-		return "minecraft:purpur_stairs"
+		return "minecraft:purpur_stairs", nil
 	case Purpur_double_slab:
-		return "minecraft:purpur_double_slab"
+		return "minecraft:purpur_double_slab", nil
 	case Purpur_slab:
-		return "minecraft:purpur_slab"
+		return "minecraft:purpur_slab", nil
 	case End_bricks:
-		return "minecraft:end_bricks"
+		return "minecraft:end_bricks", nil
 	case Beetroots:
-		return "minecraft:beetroots"
+		return "minecraft:beetroots", nil
 	case Grass_path:
-		return "minecraft:grass_path"
+		return "minecraft:grass_path", nil
 	case End_gateway:
-		return "minecraft:end_gateway"
+		return "minecraft:end_gateway", nil
 	case Repeating_command_block:
-		return "minecraft:repeating_command_block"
+		return "minecraft:repeating_command_block", nil
 	case Chain_command_block:
-		return "minecraft:chain_command_block"
+		return "minecraft:chain_command_block", nil
 	case Frosted_ice:
-		return "minecraft:frosted_ice"
+		return "minecraft:frosted_ice", nil
 	case Magma:
-		return "minecraft:magma"
+		return "minecraft:magma", nil
 	case Nether_wart_block:
-		return "minecraft:nether_wart_block"
+		return "minecraft:nether_wart_block", nil
 	case Red_nether_brick:
-		return "minecraft:red_nether_brick"
+		return "minecraft:red_nether_brick", nil
 	case Bone_block:
-		return "minecraft:bone_block"
+		return "minecraft:bone_block", nil
 	case Structure_void:
-		return "minecraft:structure_void"
+		return "minecraft:structure_void", nil
 	case Observer:
-		return "minecraft:observer"
+		return "minecraft:observer", nil
 	case White_shulker_box:
-		return "minecraft:white_shulker_box"
+		return "minecraft:white_shulker_box", nil
 	case Orange_shulker_box:
-		return "minecraft:orange_shulker_box"
+		return "minecraft:orange_shulker_box", nil
 	case Magenta_shulker_box:
-		return "minecraft:magenta_shulker_box"
+		return "minecraft:magenta_shulker_box", nil
 	case Light_blue_shulker_box:
-		return "minecraft:light_blue_shulker_box"
+		return "minecraft:light_blue_shulker_box", nil
 	case Yellow_shulker_box:
-		return "minecraft:yellow_shulker_box"
+		return "minecraft:yellow_shulker_box", nil
 	case Lime_shulker_box:
-		return "minecraft:lime_shulker_box"
+		return "minecraft:lime_shulker_box", nil
 	case Pink_shulker_box:
-		return "minecraft:pink_shulker_box"
+		return "minecraft:pink_shulker_box", nil
 	case Gray_shulker_box:
-		return "minecraft:gray_shulker_box"
+		return "minecraft:gray_shulker_box", nil
 	case Light_gray_shulker_box:
-		return "minecraft:light_gray_shulker_box"
+		return "minecraft:light_gray_shulker_box", nil
 	case Cyan_shulker_box:
-		return "minecraft:cyan_shulker_box"
+		return "minecraft:cyan_shulker_box", nil
 	case Purple_shulker_box:
-		return "minecraft:purple_shulker_box"
+		return "minecraft:purple_shulker_box", nil
 	case Blue_shulker_box:
-		return "minecraft:blue_shulker_box"
+		return "minecraft:blue_shulker_box", nil
 	case Brown_shulker_box:
-		return "minecraft:brown_shulker_box"
+		return "minecraft:brown_shulker_box", nil
 	case Green_shulker_box:
-		return "minecraft:green_shulker_box"
+		return "minecraft:green_shulker_box", nil
 	case Red_shulker_box:
-		return "minecraft:red_shulker_box"
+		return "minecraft:red_shulker_box", nil
 	case Black_shulker_box:
-		return "minecraft:black_shulker_box"
+		return "minecraft:black_shulker_box", nil
 	case Structure_block:
-		return "minecraft:structure_block"
+		return "minecraft:structure_block", nil
+	}
+	return "", &UnknownBlockError{b.Id}
+}
+
+// names which haven't changed since before the flattening, built from Name()
+var legacyIds = make(map[string]int16)
+
+func init() {
+	for id := int16(Air); id <= Structure_block; id++ {
+		legacyIds[NewBlock(id, 0).Name()] = id
 	}
-	panic(fmt.Sprintf("Block.Name: unhandled id %d", b.Id))
+}
+
+// names introduced (or reused for something else) by the 1.13 flattening,
+// mapped to the legacy id which looks most like them on a map
+var flattenedIds = map[string]int16{
+	"minecraft:cave_air":           Air,
+	"minecraft:void_air":           Air,
+	"minecraft:granite":            Stone,
+	"minecraft:polished_granite":   Stone,
+	"minecraft:diorite":            Stone,
+	"minecraft:polished_diorite":   Stone,
+	"minecraft:andesite":           Stone,
+	"minecraft:polished_andesite":  Stone,
+	"minecraft:grass_block":        Grass,
+	"minecraft:coarse_dirt":        Dirt,
+	"minecraft:podzol":             Dirt,
+	"minecraft:oak_planks":         Planks,
+	"minecraft:spruce_planks":      Planks,
+	"minecraft:birch_planks":       Planks,
+	"minecraft:jungle_planks":      Planks,
+	"minecraft:acacia_planks":      Planks,
+	"minecraft:dark_oak_planks":    Planks,
+	"minecraft:oak_sapling":        Sapling,
+	"minecraft:spruce_sapling":     Sapling,
+	"minecraft:birch_sapling":      Sapling,
+	"minecraft:jungle_sapling":     Sapling,
+	"minecraft:acacia_sapling":     Sapling,
+	"minecraft:dark_oak_sapling":   Sapling,
+	"minecraft:red_sand":           Sand,
+	"minecraft:oak_log":            Log,
+	"minecraft:spruce_log":         Log,
+	"minecraft:birch_log":          Log,
+	"minecraft:jungle_log":         Log,
+	"minecraft:acacia_log":         Log2,
+	"minecraft:dark_oak_log":       Log2,
+	"minecraft:oak_leaves":         Leaves,
+	"minecraft:spruce_leaves":      Leaves,
+	"minecraft:birch_leaves":       Leaves,
+	"minecraft:jungle_leaves":      Leaves,
+	"minecraft:acacia_leaves":      Leaves2,
+	"minecraft:dark_oak_leaves":    Leaves2,
+	"minecraft:chiseled_sandstone": Sandstone,
+	"minecraft:cut_sandstone":      Sandstone,
+	"minecraft:cobweb":             Web,
+	"minecraft:grass":              Tallgrass, // the plant, not the block
+	"minecraft:short_grass":        Tallgrass,
+	"minecraft:fern":               Tallgrass,
+	"minecraft:dead_bush":          Deadbush,
+	"minecraft:tall_grass":         Double_plant,
+	"minecraft:large_fern":         Double_plant,
+	"minecraft:white_wool":         Wool,
+	"minecraft:snow":               Snow_layer, // the layer, not the block
+	"minecraft:snow_block":         Snow,
+	"minecraft:sugar_cane":         Reeds,
+	"minecraft:melon":              Melon_block,
+	"minecraft:lily_pad":           Waterlily,
+	"minecraft:nether_bricks":      Nether_brick,
+	"minecraft:red_nether_bricks":  Red_nether_brick,
+	"minecraft:nether_quartz_ore":  Quartz_ore,
+	"minecraft:end_stone_bricks":   End_bricks,
+	"minecraft:terracotta":         Hardened_clay,
+	"minecraft:magma_block":        Magma,
 }
 
 func (b Block) Block() string {
@@ -773,6 +915,71 @@ func (b Block) Colour() color.RGBA {
 		return color.RGBA{240, 230, 140, 255} //Khaki
 	case Snow, Snow_layer:
 		return color.RGBA{255, 250, 250, 244} // Snow
+	// nether
+	case Netherrack, Quartz_ore:
+		return color.RGBA{111, 54, 52, 255}
+	case Soul_sand:
+		return color.RGBA{84, 64, 51, 255}
+	case Glowstone:
+		return color.RGBA{250, 210, 130, 255}
+	case Magma:
+		return color.RGBA{142, 63, 31, 255}
+	case Nether_wart_block, Nether_wart:
+		return color.RGBA{114, 2, 2, 255}
+	case Nether_brick, Nether_brick_fence, Nether_brick_stairs, Red_nether_brick:
+		return color.RGBA{44, 21, 26, 255}
+	case Bedrock:
+		return color.RGBA{85, 85, 85, 255}
+	// end
+	case End_stone, End_bricks:
+		return color.RGBA{219, 222, 158, 255}
+	case Purpur_block, Purpur_pillar, Purpur_stairs, Purpur_double_slab, Purpur_slab:
+		return color.RGBA{169, 125, 169, 255}
+	case Chorus_plant:
+		return color.RGBA{93, 57, 93, 255}
+	case Chorus_flower:
+		return color.RGBA{151, 120, 151, 255}
+	case Obsidian, Dragon_egg:
+		return color.RGBA{20, 18, 29, 255}
+	case End_rod:
+		return color.RGBA{240, 240, 240, 255}
+	}
+	if b.Id < 0 {
+		return namedColour(b.name)
+	}
+	return color.RGBA{0, 0, 0, 255}
+}
+
+// colours for blocks added since the flattening, which don't have a legacy
+// id to switch on
+func namedColour(name string) color.RGBA {
+	switch name {
+	case "minecraft:crimson_nylium":
+		return color.RGBA{130, 31, 31, 255}
+	case "minecraft:warped_nylium":
+		return color.RGBA{43, 114, 101, 255}
+	case "minecraft:crimson_stem", "minecraft:crimson_hyphae", "minecraft:crimson_fungus", "minecraft:crimson_roots", "minecraft:weeping_vines":
+		return color.RGBA{92, 25, 29, 255}
+	case "minecraft:warped_stem", "minecraft:warped_hyphae":
+		return color.RGBA{58, 58, 77, 255}
+	case "minecraft:warped_wart_block", "minecraft:warped_fungus", "minecraft:warped_roots", "minecraft:twisting_vines":
+		return color.RGBA{22, 119, 121, 255}
+	case "minecraft:shroomlight":
+		return color.RGBA{240, 146, 70, 255}
+	case "minecraft:soul_soil":
+		return color.RGBA{75, 57, 46, 255}
+	case "minecraft:basalt", "minecraft:polished_basalt", "minecraft:smooth_basalt":
+		return color.RGBA{73, 72, 77, 255}
+	case "minecraft:blackstone", "minecraft:gilded_blackstone":
+		return color.RGBA{42, 36, 41, 255}
+	case "minecraft:nether_gold_ore":
+		return color.RGBA{115, 54, 42, 255}
+	case "minecraft:ancient_debris":
+		return color.RGBA{94, 66, 58, 255}
+	case "minecraft:crying_obsidian":
+		return color.RGBA{32, 10, 60, 255}
+	case "minecraft:deepslate", "minecraft:cobbled_deepslate", "minecraft:tuff":
+		return color.RGBA{80, 80, 82, 255}
 	}
 	return color.RGBA{0, 0, 0, 255}
 }
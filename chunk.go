@@ -1,7 +1,9 @@
 package mapper
 
+import "bytes"
 import "errors"
 import "fmt"
+import "sync"
 
 import "github.com/timocp/nbt"
 
@@ -9,36 +11,144 @@ var EmptySectionError = errors.New("section is empty")
 var airBlock = NewBlock(0, 0)
 
 type Chunk struct {
-	root    nbt.Tag
+	root    nbt.CompoundTag
 	regionX int
 	regionZ int
 	chunkX  int
 	chunkZ  int
+	// the version of Minecraft which wrote the chunk; 0 if it's too old to
+	// say
+	DataVersion int
+	decoder     Decoder
+	// decoded sections, by Y.  Chunks are shared by Dimension lookups, so
+	// this is locked
+	mu     sync.Mutex
+	blocks map[int]*SectionBlocks
 }
 
-func NewChunk(tag nbt.Tag, region *Region, x int, z int) *Chunk {
-	return &Chunk{tag, region.X, region.Z, x, z}
+func NewChunk(tag nbt.Tag, region *Region, x int, z int) (*Chunk, error) {
+	root, ok := tag.(nbt.CompoundTag)
+	if !ok {
+		return nil, tagTypeError("root", root, tag)
+	}
+	c := &Chunk{root: root, regionX: region.X, regionZ: region.Z, chunkX: x, chunkZ: z}
+	if root.ChildByName("DataVersion") != nil {
+		v, err := intChild(root, "DataVersion")
+		if err != nil {
+			return nil, err
+		}
+		c.DataVersion = v
+	}
+	c.decoder = decoderFor(c.DataVersion)
+	return c, nil
+}
+
+// parses uncompressed chunk data (as returned by Region.ChunkData) into a
+// Chunk.  Corrupt data is returned as a ParseError rather than a panic
+func ParseChunk(data []byte, region *Region, x int, z int) (c *Chunk, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			c, err = nil, &ParseError{r}
+		}
+	}()
+	return NewChunk(nbt.Parse(bytes.NewReader(data)), region, x, z)
 }
 
 func (c *Chunk) Root() nbt.CompoundTag {
-	return c.root.(nbt.CompoundTag)
+	return c.root
 }
 
-func (c *Chunk) Level() nbt.CompoundTag {
-	return c.Root().ChildByName("Level").(nbt.CompoundTag)
+// the compound holding the chunk's data.  Since 1.18 there's no "Level"
+// wrapper and this is the root
+func (c *Chunk) Level() (nbt.CompoundTag, error) {
+	return c.decoder.Level(c.root)
+}
+
+func (c *Chunk) Biomes() ([]byte, error) {
+	level, err := c.Level()
+	if err != nil {
+		return nil, err
+	}
+	return byteArrayChild(level, "Biomes")
+}
+
+// returns the namespaced biome (eg "minecraft:plains") at coords x y z of a
+// 1.18+ chunk, where biomes are stored per section in 4x4x4 cells.  Returns
+// "" for older chunks, see Biomes()
+func (c *Chunk) BiomeName(x int, y int, z int) (string, error) {
+	section, err := c.Section(y >> 4)
+	if err == EmptySectionError {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if section.ChildByName("biomes") == nil {
+		return "", nil
+	}
+	biomes, err := compoundChild(section, "biomes")
+	if err != nil {
+		return "", err
+	}
+	palette, err := listChild(biomes, "palette")
+	if err != nil {
+		return "", err
+	}
+	if len(palette.Values) == 0 {
+		return "", fmt.Errorf("empty biome palette")
+	}
+	i := 0
+	if biomes.ChildByName("data") != nil {
+		data, err := longArrayChild(biomes, "data")
+		if err != nil {
+			return "", err
+		}
+		bits := paletteBits(len(palette.Values), 1)
+		if len(data) < packedLen(bits, 64, false) {
+			return "", fmt.Errorf("biome data too short (%d)", len(data))
+		}
+		cell := ((y&15)/4)*16 + (z/4)*4 + x/4
+		i = unpack(data, bits, false, cell)
+		if i >= len(palette.Values) {
+			return "", fmt.Errorf("biome %d out of range of palette of %d", i, len(palette.Values))
+		}
+	}
+	name, ok := palette.Values[i].(nbt.StringTag)
+	if !ok {
+		return "", tagTypeError("palette", name, palette.Values[i])
+	}
+	return name.Value, nil
 }
 
-func (c *Chunk) Biomes() []byte {
-	return c.Level().ChildByName("Biomes").(nbt.ByteArrayTag).Values
+// the sections which have blocks in them
+func (c *Chunk) sections() ([]nbt.CompoundTag, error) {
+	level, err := c.Level()
+	if err != nil {
+		return nil, err
+	}
+	return c.decoder.Sections(level)
 }
 
 // return the index of the highest section in this chunk - above this is only
 // air
-func (c *Chunk) MaxSection() (max int) {
-	for _, s := range c.Level().ChildByName("Sections").(nbt.ListTag).Values {
-		i := int(s.(nbt.CompoundTag).ChildByName("Y").(nbt.ByteTag).Value)
-		if i > max {
-			max = i
+func (c *Chunk) MaxSection() (max int, err error) {
+	sections, err := c.sections()
+	for i, s := range sections {
+		y, _ := byteChild(s, "Y")
+		if i == 0 || y > max {
+			max = y
+		}
+	}
+	return
+}
+
+// return the index of the lowest section in this chunk - below this is only
+// air (or the void).  Negative since 1.18
+func (c *Chunk) MinSection() (min int, err error) {
+	sections, err := c.sections()
+	for i, s := range sections {
+		y, _ := byteChild(s, "Y")
+		if i == 0 || y < min {
+			min = y
 		}
 	}
 	return
@@ -47,9 +157,13 @@ func (c *Chunk) MaxSection() (max int) {
 // returns the section with index Y=y.  If it doesn't exist, returns an empty
 // section
 func (c *Chunk) Section(y int) (result nbt.CompoundTag, err error) {
-	for _, s := range c.Level().ChildByName("Sections").(nbt.ListTag).Values {
-		if int(s.(nbt.CompoundTag).ChildByName("Y").(nbt.ByteTag).Value) == y {
-			result = s.(nbt.CompoundTag)
+	sections, err := c.sections()
+	if err != nil {
+		return
+	}
+	for _, s := range sections {
+		if sy, _ := byteChild(s, "Y"); sy == y {
+			result = s
 			return
 		}
 	}
@@ -58,30 +172,73 @@ func (c *Chunk) Section(y int) (result nbt.CompoundTag, err error) {
 }
 
 // accessor methods to values inside chunks
-func (c *Chunk) HeightMap() []int32 {
-	return c.Level().ChildByName("HeightMap").(nbt.IntArrayTag).Values
+func (c *Chunk) HeightMap() ([]int32, error) {
+	level, err := c.Level()
+	if err != nil {
+		return nil, err
+	}
+	return c.decoder.HeightMap(level)
+}
+
+// the chunk coordinates stored in the chunk itself, which should agree with
+// X() and Z()
+func (c *Chunk) StoredPos() (x int, z int, err error) {
+	level, err := c.Level()
+	if err != nil {
+		return
+	}
+	if x, err = intChild(level, "xPos"); err != nil {
+		return
+	}
+	z, err = intChild(level, "zPos")
+	return
+}
+
+// the number of ticks players have spent in this chunk, summed over players
+func (c *Chunk) InhabitedTime() (int64, error) {
+	level, err := c.Level()
+	if err != nil {
+		return 0, err
+	}
+	return longChild(level, "InhabitedTime")
 }
 
 // returns the Block found at coords x y z
-func (c *Chunk) BlockAt(x int, y int, z int) Block {
-	// get the section which stores this block
-	section, err := c.Section(y / 16)
-	if err == EmptySectionError {
-		return airBlock
-	} else if err != nil {
-		panic(fmt.Sprintf("BlockAt: %s", err.Error()))
+func (c *Chunk) BlockAt(x int, y int, z int) (Block, error) {
+	// y may be negative, so use shifts rather than / and % to round down
+	blocks, err := c.sectionBlocks(y >> 4)
+	if err != nil {
+		return airBlock, fmt.Errorf("BlockAt %d %d %d: %s", x, y, z, err)
+	}
+	if blocks == nil {
+		return airBlock, nil
+	}
+	return blocks.At(x, y&15, z), nil
+}
+
+// returns the decoded blocks of section y, or nil if it's empty.  Decoded
+// sections are kept because BlockAt is called for many blocks of the same
+// section
+func (c *Chunk) sectionBlocks(y int) (*SectionBlocks, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if blocks, ok := c.blocks[y]; ok {
+		return blocks, nil
+	}
+	var blocks *SectionBlocks
+	section, err := c.Section(y)
+	if err == nil {
+		if blocks, err = c.decoder.Blocks(section); err != nil {
+			return nil, err
+		}
+	} else if err != EmptySectionError {
+		return nil, err
 	}
-	blockPos := (y%16)*16*16 + z*16 + x
-	//fmt.Printf("x=%d y=%d z=%d blockPos=%d\n", x, y, z, blockPos)
-	idA := section.ChildByName("Blocks").(nbt.ByteArrayTag).Values[blockPos]
-	add := section.ChildByName("Add")
-	idB := byte(0)
-	if add != nil {
-		idB = nibble4(add.(nbt.ByteArrayTag).Values, blockPos)
+	if c.blocks == nil {
+		c.blocks = make(map[int]*SectionBlocks)
 	}
-	data := nibble4(section.ChildByName("Data").(nbt.ByteArrayTag).Values, blockPos)
-	//fmt.Printf("newblock idA=%d idB=%d data=%d\n", idA, idB, data)
-	return NewBlock(int16(idA)+int16(idB)<<8, int8(data))
+	c.blocks[y] = blocks
+	return blocks, nil
 }
 
 // walk the NBT data structure and print it out
@@ -96,15 +253,3 @@ func (c *Chunk) X() int {
 func (c *Chunk) Z() int {
 	return c.regionZ*32 + c.chunkZ
 }
-
-// from examples on http://minecraft.gamepedia.com/Chunk_format#Block_format
-//
-// from a byte array where each byte is a 4 bit number, extract the item at
-// the specified index
-func nibble4(arr []byte, index int) byte {
-	if index%2 == 0 {
-		return arr[index/2] & 0x0F
-	} else {
-		return (arr[index/2] >> 4) & 0x0F
-	}
-}
@@ -0,0 +1,82 @@
+package main
+
+import "encoding/json"
+import "flag"
+import "fmt"
+import "log"
+import "os"
+import "text/tabwriter"
+import "time"
+
+import "github.com/timocp/mapper"
+
+// one row of the chunks listing
+type chunkRow struct {
+	Region      string    `json:"region"`
+	X           int       `json:"x"` // world chunk coordinates
+	Z           int       `json:"z"`
+	Sector      int       `json:"sector"`
+	Sectors     int       `json:"sectors"`
+	Length      int       `json:"length"`
+	Compression string    `json:"compression"`
+	External    bool      `json:"external"`
+	Modified    time.Time `json:"modified"`
+}
+
+// map chunks [-json] [-since date] regionfile...
+//
+// lists the chunks present in each region file with where and how they're
+// stored and when they were last saved
+func chunksMain(args []string) {
+	flags := flag.NewFlagSet("chunks", flag.ExitOnError)
+	optJSON := flags.Bool("json", false, "print a JSON array instead of a table")
+	optSince := flags.String("since", "", "only list chunks saved on or after this date (YYYY-MM-DD)")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s chunks [options] regionfile...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	var since time.Time
+	if *optSince != "" {
+		var err error
+		since, err = time.ParseInLocation("2006-01-02", *optSince, time.Local)
+		must(err)
+	}
+	rows := []chunkRow{}
+	for _, fn := range flags.Args() {
+		r := new(mapper.Region)
+		if err := r.Open(fn); err != nil {
+			log.Printf("%s: %s", fn, err)
+			continue
+		}
+		chunks, err := r.Chunks()
+		r.Close()
+		if err != nil {
+			log.Printf("%s: %s", fn, err)
+		}
+		for _, info := range chunks {
+			if info.Timestamp.Before(since) {
+				continue
+			}
+			rows = append(rows, chunkRow{fn, r.X*32 + info.X, r.Z*32 + info.Z, info.Sector, info.Sectors,
+				info.Length, info.Compression.String(), info.External, info.Timestamp})
+		}
+	}
+	if *optJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		must(enc.Encode(rows))
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "x\tz\tsector\tsectors\tlength\tcompression\tmodified\tregion\t")
+	for _, row := range rows {
+		compression := row.Compression
+		if row.External {
+			compression += " (mcc)"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t\n", row.X, row.Z, row.Sector, row.Sectors,
+			row.Length, compression, row.Modified.Format("2006-01-02 15:04:05"), row.Region)
+	}
+	tw.Flush()
+}
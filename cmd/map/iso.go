@@ -0,0 +1,251 @@
+package main
+
+import "fmt"
+import "image"
+import "image/color"
+import "image/draw"
+import "sort"
+
+import "github.com/timocp/mapper"
+
+// isometric maps draw each block as a cube 2*isoHalf pixels wide and high:
+// a diamond for its top, above parallelograms for its two front faces
+const isoHalf = 4
+
+// brightness of each face of a cube, as if lit from above
+const (
+	isoTop   = 1.0
+	isoLeft  = 0.8
+	isoRight = 0.6
+)
+
+// which face of the cube each pixel of its sprite belongs to
+type isoFace byte
+
+const (
+	isoNone isoFace = iota
+	isoFaceTop
+	isoFaceLeft
+	isoFaceRight
+)
+
+// the most pixels an isometric map may have.  They're put together in
+// memory from every chunk at once, so bigger ones are refused rather than
+// running out of it
+const isoMaxPixels = 1 << 28
+
+// the most blocks of height a dimension has (-64 to 319 in the overworld)
+const isoMaxHeight = 384
+
+var isoMask = makeIsoMask()
+
+func makeIsoMask() (mask [2 * isoHalf][2 * isoHalf]isoFace) {
+	const h = float64(isoHalf)
+	for py := range mask {
+		for px := range mask[py] {
+			x, y := float64(px)+0.5, float64(py)+0.5
+			// distance from the centre line, so both halves are the same
+			dx := x
+			if x > h {
+				dx = 2*h - x
+			}
+			switch {
+			case y < h/2-dx/2:
+				// above the top diamond
+			case y < h/2+dx/2:
+				mask[py][px] = isoFaceTop
+			case y > 3*h/2+dx/2:
+				// below the front faces
+			case x < h:
+				mask[py][px] = isoFaceLeft
+			default:
+				mask[py][px] = isoFaceRight
+			}
+		}
+	}
+	return
+}
+
+// rotates world x z into screen axes u v, where u runs down and to the right
+// and v down and to the left.  Each rotation turns the map a quarter
+// clockwise.  Works for both block and chunk coordinates
+func isoRotate(x int, z int, rotation int) (u int, v int) {
+	switch rotation & 3 {
+	case 1:
+		return -z - 1, x
+	case 2:
+		return -x - 1, -z - 1
+	case 3:
+		return z, -x - 1
+	}
+	return x, z
+}
+
+// the inverse of isoRotate
+func isoUnrotate(u int, v int, rotation int) (x int, z int) {
+	switch rotation & 3 {
+	case 1:
+		return v, -u - 1
+	case 2:
+		return -u - 1, -v - 1
+	case 3:
+		return -v - 1, u
+	}
+	return u, v
+}
+
+// the world x z steps which increase u and v
+func isoAxes(rotation int) (du [2]int, dv [2]int) {
+	x0, z0 := isoUnrotate(0, 0, rotation)
+	x1, z1 := isoUnrotate(1, 0, rotation)
+	x2, z2 := isoUnrotate(0, 1, rotation)
+	return [2]int{x1 - x0, z1 - z0}, [2]int{x2 - x0, z2 - z0}
+}
+
+// where the top left of the cube at u v y is drawn
+func isoPoint(u int, v int, y int) image.Point {
+	return image.Point{(u - v) * isoHalf, (u+v)*isoHalf/2 - y*isoHalf}
+}
+
+// draws the visible blocks of c in 2.5D.  The image's bounds are in the
+// coordinates of the whole map, and chunkImage x z are the chunk's u v, so
+// chunks can be drawn over each other in order of u+v
+func genIsometricImage(c *mapper.Chunk, opts *renderOptions) (chunkImage, error) {
+	top, bottom, err := searchRange(c, opts)
+	if err != nil {
+		return chunkImage{}, err
+	}
+	cu, cv := isoRotate(c.X(), c.Z(), opts.rotation)
+	du, dv := isoAxes(opts.rotation)
+	// big enough for every block from top to bottom: the left and right
+	// corners are the cubes at the ends of the v and u axes
+	img := image.NewRGBA(image.Rect(
+		isoPoint(cu*16, cv*16+15, 0).X,
+		isoPoint(cu*16, cv*16, top).Y,
+		isoPoint(cu*16+15, cv*16, 0).X+2*isoHalf,
+		isoPoint(cu*16+15, cv*16+15, bottom).Y+2*isoHalf,
+	))
+	// the block at local x y z, or air if it's outside the chunk
+	blockAt := func(x int, y int, z int) (mapper.Block, error) {
+		if x < 0 || x > 15 || z < 0 || z > 15 || y > top {
+			return mapper.NewBlock(mapper.Air, 0), nil
+		}
+		return c.BlockAt(x, y, z)
+	}
+	// cubes further back are drawn first, so nearer ones cover them: any
+	// cube in front of another is at least as far along all of u, v and y
+	drawn := image.Rectangle{}
+	for y := bottom; y <= top; y++ {
+		for lu := 0; lu < 16; lu++ {
+			for lv := 0; lv < 16; lv++ {
+				wx, wz := isoUnrotate(cu*16+lu, cv*16+lv, opts.rotation)
+				x, z := wx-c.X()*16, wz-c.Z()*16
+				block, err := c.BlockAt(x, y, z)
+				if err != nil {
+					return chunkImage{}, err
+				}
+				if block.Id == mapper.Air {
+					continue
+				}
+				// skip cubes which are covered above and in front
+				hidden := true
+				for _, n := range [][3]int{{0, 1, 0}, {du[0], 0, du[1]}, {dv[0], 0, dv[1]}} {
+					b, err := blockAt(x+n[0], y+n[1], z+n[2])
+					if err != nil {
+						return chunkImage{}, err
+					}
+					if b.Id == mapper.Air {
+						hidden = false
+						break
+					}
+				}
+				if hidden {
+					continue
+				}
+				p := isoPoint(cu*16+lu, cv*16+lv, y)
+				colour, err := blockColour(c, opts, block, x, y, z)
+				if err != nil {
+					return chunkImage{}, err
+				}
+				drawCube(img, p, colour)
+				drawn = drawn.Union(image.Rectangle{p, p.Add(image.Point{2 * isoHalf, 2 * isoHalf})})
+			}
+		}
+	}
+	// most of the image is usually the empty space below the surface
+	cropped := image.NewRGBA(drawn)
+	draw.Draw(cropped, drawn, img, drawn.Min, draw.Src)
+	return chunkImage{x: cu, z: cv, img: cropped}, nil
+}
+
+// draws a cube with its top left at p, shading each face
+func drawCube(img *image.RGBA, p image.Point, c color.RGBA) {
+	faces := [...]color.RGBA{
+		isoFaceTop:   shadeColour(c, isoTop),
+		isoFaceLeft:  shadeColour(c, isoLeft),
+		isoFaceRight: shadeColour(c, isoRight),
+	}
+	for py, row := range isoMask {
+		for px, face := range row {
+			if face != isoNone {
+				img.SetRGBA(p.X+px, p.Y+py, faces[face])
+			}
+		}
+	}
+}
+
+func shadeColour(c color.RGBA, factor float64) color.RGBA {
+	return color.RGBA{scale(c.R, factor), scale(c.G, factor), scale(c.B, factor), c.A}
+}
+
+// draws the chunk images from genIsometricImage back to front onto one
+// image, with the top left of the map at 0,0
+func composeIsometric(images []chunkImage) *image.RGBA {
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].x+images[i].z < images[j].x+images[j].z
+	})
+	var bounds image.Rectangle
+	for _, ci := range images {
+		bounds = bounds.Union(ci.img.Bounds())
+	}
+	img := image.NewRGBA(bounds.Sub(bounds.Min))
+	for _, ci := range images {
+		r := ci.img.Bounds()
+		draw.Draw(img, r.Sub(bounds.Min), ci.img, r.Min, draw.Over)
+	}
+	return img
+}
+
+// returns an error if an isometric map of the chunks in extent (in world
+// chunk coordinates) could have more than isoMaxPixels
+func checkIsometricSize(extent image.Rectangle, rotation int) error {
+	var minu, maxu, minv, maxv int
+	corners := []image.Point{
+		extent.Min,
+		{extent.Max.X - 1, extent.Min.Y},
+		{extent.Min.X, extent.Max.Y - 1},
+		extent.Max.Sub(image.Point{1, 1}),
+	}
+	for i, c := range corners {
+		u, v := isoRotate(c.X, c.Y, rotation)
+		if i == 0 || u < minu {
+			minu = u
+		}
+		if i == 0 || u > maxu {
+			maxu = u
+		}
+		if i == 0 || v < minv {
+			minv = v
+		}
+		if i == 0 || v > maxv {
+			maxv = v
+		}
+	}
+	width := int64(maxu-minu+maxv-minv+2) * 16 * isoHalf
+	height := int64(maxu+maxv-minu-minv+2)*16*isoHalf/2 + isoMaxHeight*isoHalf
+	if width*height > isoMaxPixels {
+		return fmt.Errorf("an isometric map of chunks x %d..%d, z %d..%d could be %dx%d pixels, too big to put together in memory; map fewer region files",
+			extent.Min.X, extent.Max.X-1, extent.Min.Y, extent.Max.Y-1, width, height)
+	}
+	return nil
+}
@@ -1,176 +1,247 @@
 package main
 
-import "bytes"
+import "context"
 import "flag"
 import "fmt"
 import "image"
 import "image/color"
-import "image/draw"
-import "image/png"
 import "log"
+import "math"
 import "os"
+import "os/signal"
+import "runtime"
 import "sync"
 
 import "github.com/timocp/mapper"
-import "github.com/timocp/nbt"
+
+// how to render each chunk, from the command line
+type renderOptions struct {
+	mapType   string
+	dimension string // namespaced, eg mapper.Nether
+	ceiling   int    // if not unsetY, look for the surface below this
+	level     int    // the Y rendered by slice maps, if not unsetY
+	shading   *shading
+	rotation  int // quarter turns clockwise, for isometric maps
+	palette   *mapper.Palette
+	tint      bool // colour grass, leaves and water by biome
+	tinter    *mapper.Tinter
+	workers   int // chunks rendered at once
+	// if not nil, only chunks (by world chunk x z) for which this is true
+	// are rendered
+	only func(x int, z int) bool
+	// if not nil, called with chunks (by world chunk x z) which couldn't be
+	// rendered, as well as them being logged.  May be called from several
+	// goroutines at once
+	failed func(x int, z int)
+}
+
+// for flags which default to not given
+const unsetY = math.MinInt32
+
+// where to start looking in the nether, below the bedrock roof at 123-127
+const netherCeiling = 120
+
+// the colour of columns with nothing in them in the end
+var endVoid = color.RGBA{16, 12, 28, 255}
 
 // a 16x16 image fragment for a single chunk, which knows its world x/z offset
 type chunkImage struct {
 	x   int
 	z   int
 	img image.Image
+	// the Y of the block drawn in each column (in ZX order), for shading.
+	// nil for maps which don't show the surface
+	heights []int
 }
 
 func main() {
-	optType := flag.String("type", "terrain", "type of map to generate(biomes, height, terrain)")
-	flag.Parse()
-	var images []chunkImage
-	var wg sync.WaitGroup
-	chImages := make(chan chunkImage)
-	var minx, maxx, minz, maxz int
-	for _, fn := range flag.Args() {
-		wg.Add(1)
-		go func(fn string) {
-			defer wg.Done()
-			imageRegion(fn, optType, chImages)
-		}(fn)
-	}
-	go func() {
-		// separate goroutine to close the channel when all files have
-		// been read
-		wg.Wait()
-		close(chImages)
-	}()
-	// main routine reads from the channel until it is closed
-	for ci := range chImages {
-		images = append(images, ci)
-	}
-	// work out the world min/max offsets so we know where the image 0,0 is
-	for _, ci := range images {
-		if ci.x < minx {
-			minx = ci.x
-		}
-		if ci.x > maxx {
-			maxx = ci.x
+	// subcommands other than rendering
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "prune":
+			pruneMain(os.Args[2:])
+			return
+		case "verify":
+			verifyMain(os.Args[2:])
+			return
+		case "chunks":
+			chunksMain(os.Args[2:])
+			return
+		case "serve":
+			serveMain(os.Args[2:])
+			return
 		}
-		if ci.z < minz {
-			minz = ci.z
-		}
-		if ci.z > maxz {
-			maxz = ci.z
+	}
+	newOptions := renderFlags(flag.CommandLine)
+	optTiles := flag.String("tiles", "", "write the map as a pyramid of z/x/y.png tiles with an index.html viewer into this directory, rather than one image.  Only tiles with chunks saved since the last run are rendered again")
+	optFull := flag.Bool("full", false, "with -tiles, render every tile even if its chunks haven't changed")
+	optWorkers := flag.Int("workers", runtime.NumCPU(), "number of chunks to render at once")
+	flag.Parse()
+	opts := newOptions()
+	opts.workers = *optWorkers
+	// stop rendering on ^C, leaving no half written map or manifest
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	files, info := regionFiles(flag.Args(), opts.dimension)
+	text := map[string]string{"Software": "mapper"}
+	if info != nil {
+		text["Title"] = info.Name
+		if info.Version != "" {
+			text["Comment"] = "Minecraft " + info.Version
 		}
 	}
-	fmt.Printf("imaged %d chunks (x: %d..%d, z: %d..%d)\n", len(images), minx, maxx, minz, maxz)
-	// create an image large enough to place all the chunks onto
-	img := image.NewRGBA(image.Rect(0, 0, (maxx-minx+1)*16, (maxz-minz+1)*16))
-	// now compose all the mini images into a big one
-	for _, ci := range images {
-		xoffset := (ci.x - minx) * 16
-		zoffset := (ci.z - minz) * 16
-		r := image.Rect(xoffset, zoffset, xoffset+16, zoffset+16)
-		draw.Draw(img, r, ci.img, image.Point{0, 0}, draw.Src)
+	if *optTiles != "" {
+		must(planTiles(*optTiles, files, opts, *optFull).write(ctx, files, opts, info, text))
+		return
+	}
+	if opts.mapType != "isometric" {
+		must(streamFlat(ctx, opts.mapType+".png", files, opts, info, text))
+		return
 	}
-	output, err := os.Create(*optType + ".png")
+	// isometric maps are put together from all the chunks at once
+	extent, _ := scanExtent(files)
+	must(checkIsometricSize(extent, opts.rotation))
+	var images []chunkImage
+	must(renderRegions(ctx, files, opts, func(ci chunkImage) {
+		images = append(images, ci)
+	}))
+	fmt.Printf("imaged %d chunks\n", len(images))
+	img := composeIsometric(images)
+	output, err := os.Create(opts.mapType + ".png")
 	defer output.Close()
 	must(err)
-	png.Encode(output, img)
+	must(encodePNG(output, img, text))
 }
 
-// parses a region file, generating an image for each chunk and sending them to c
-func imageRegion(fn string, optType *string, c chan chunkImage) {
-	r := new(mapper.Region)
-	fmt.Printf("Reading %s\n", fn)
-	must(r.Open(fn))
-	for x := 0; x < 32; x++ {
-		for z := 0; z < 32; z++ {
-			chunkData, err := r.ChunkData(x, z)
-			must(err)
-			if chunkData.Len() > 0 {
-				chunk := mapper.NewChunk(nbt.Parse(bytes.NewReader(chunkData.Bytes())), r, x, z)
-				switch *optType {
-				case "biomes":
-					c <- genBiomesImage(chunk)
-				case "terrain":
-					c <- genTerrainImage(chunk)
-				case "height":
-					c <- genHeightImage(chunk)
-				default:
-					panic(fmt.Sprintf("%s: invalid type", *optType))
-				}
+// defines the flags which control how chunks are rendered on flags.  The
+// returned function makes the options from them, once they've been parsed
+func renderFlags(flags *flag.FlagSet) func() *renderOptions {
+	optType := flags.String("type", "terrain", "type of map to generate (biomes, caves, height, isometric, slice, terrain).  Isometric maps are put together in memory from every chunk at once, so ones more than about 16k pixels square are refused; the others are written a row at a time")
+	optDimension := flags.String("dimension", "overworld", "dimension to map (overworld, nether, end or namespace:name)")
+	optCeiling := flags.Int("ceiling", unsetY, "look for the terrain below this Y (default: top of each chunk, or below the roof in the nether)")
+	optY := flags.Int("y", unsetY, "for slice maps, the Y to render (default: the first block below -ceiling)")
+	optShade := flags.Bool("shade", true, "shade terrain by height and slope (-shade=false for flat colours)")
+	optAzimuth := flags.Float64("light-azimuth", 315, "direction shading light comes from, in degrees clockwise from north")
+	optAltitude := flags.Float64("light-altitude", 45, "angle of the shading light above the horizon, in degrees (more than 0, up to 90)")
+	optRotation := flags.Int("rotation", 0, "for isometric maps, quarter turns clockwise (0-3); 0 looks north-west")
+	optPacks := flags.String("resourcepack", "", "comma separated client jars or resource packs to take block colours from, overriding packs first")
+	optPaletteCache := flags.String("palette-cache", "", "file to keep the colours from -resourcepack in, so they're only worked out again when the list of packs, or one of them, changes")
+	optPalette := flags.String("palette", "", "JSON (not YAML) file of block and biome colours, overriding the built in ones and -resourcepack")
+	optTint := flags.Bool("tint", true, "colour grass, leaves and water by biome (-tint=false for fixed colours)")
+	optColormaps := flags.String("colormaps", "", "comma separated directories holding grass.png and foliage.png, or client jars or resource packs (default: -resourcepack, or built in)")
+	return func() *renderOptions {
+		opts := &renderOptions{
+			mapType:   *optType,
+			dimension: mapper.DimensionName(*optDimension),
+			ceiling:   *optCeiling,
+			level:     *optY,
+			rotation:  *optRotation,
+			tint:      *optTint,
+		}
+		if *optShade {
+			if *optAltitude <= 0 || *optAltitude > 90 {
+				log.Fatalf("-light-altitude %g isn't between 0 and 90", *optAltitude)
 			}
+			opts.shading = &shading{*optAzimuth, *optAltitude}
+		}
+		var err error
+		opts.palette, err = loadPalette(*optPacks, *optPaletteCache, *optPalette)
+		must(err)
+		if opts.tint {
+			opts.tinter, err = loadColormaps(*optColormaps, *optPacks)
+			must(err)
+		}
+		switch opts.mapType {
+		case "biomes", "caves", "height", "isometric", "slice", "terrain":
+		default:
+			log.Fatalf("unknown -type %s", opts.mapType)
 		}
+		if opts.mapType == "slice" && opts.level == unsetY && opts.ceiling == unsetY {
+			log.Fatal("slice maps need -y or -ceiling")
+		}
+		return opts
 	}
 }
 
-func must(err error) {
-	if err != nil {
-		log.Fatal(err)
+// draws a red cross centred on x z
+func markSpawn(img *image.RGBA, x int, z int) {
+	red := color.RGBA{255, 0, 0, 255}
+	for i := -4; i <= 4; i++ {
+		img.Set(x+i, z, red)
+		img.Set(x, z+i, red)
 	}
 }
 
-func genBiomesImage(c *mapper.Chunk) chunkImage {
-	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
-	for i, v := range c.Biomes() {
-		img.Set(i%16, i/16, biomeColour(v))
+// expands any world save directories in args to the region files of the
+// given dimension.  Also returns the level.dat info of the last world
+func regionFiles(args []string, dimension string) (files []string, info *mapper.LevelInfo) {
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		must(err)
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		w, err := mapper.OpenWorld(arg)
+		must(err)
+		d, err := w.Dimension(dimension)
+		must(err)
+		files = append(files, d.RegionFiles()...)
+		info = w.Info
 	}
-	return chunkImage{c.X(), c.Z(), img}
+	return
 }
 
-// return a 16x16 chunkImage where brightness is relative to height
-func genHeightImage(c *mapper.Chunk) chunkImage {
-	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
-	for i, v := range c.HeightMap() {
-		img.Set(i%16, i/16, color.RGBA{uint8(v), uint8(v), uint8(v), 255})
+// renders the chunks of files, opts.workers at a time, calling each with
+// their images one at a time
+func renderRegions(ctx context.Context, files []string, opts *renderOptions, each func(ci chunkImage)) error {
+	var mu sync.Mutex
+	p := &mapper.Pipeline{
+		Workers: opts.workers,
+		Filter:  opts.only,
+		Error: func(err *mapper.ChunkError) {
+			log.Print(err)
+			if opts.failed != nil {
+				opts.failed(err.X, err.Z)
+			}
+		},
+		Reading: func(fn string) {
+			fmt.Printf("Reading %s\n", fn)
+		},
 	}
-	return chunkImage{c.X(), c.Z(), img}
+	return p.Run(ctx, files, func(c *mapper.Chunk) error {
+		ci, err := renderChunk(c, opts)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		each(ci)
+		return nil
+	})
 }
 
-func genTerrainImage(c *mapper.Chunk) chunkImage {
-	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
-	//fmt.Printf("x=%d z=%d\n", c.X(), c.Z())
-	for x := 0; x < 16; x++ {
-		for z := 0; z < 16; z++ {
-			// start looking at top of highest in-chunk section
-			// don't use heightmap, because that is about max light
-			y := c.MaxSection()*16 + 15
-			block := c.BlockAt(x, y, z)
-			// go down until we find something other than air
-			for block.Id == mapper.Air && y > 0 {
-				y--
-				block = c.BlockAt(x, y, z)
-			}
-			//fmt.Printf("%d.%d.%d is %s\n", c.X()*16+x, y, c.Z()*16+z, block.Name())
-			img.Set(x, z, block.Colour())
-		}
+// renders one chunk as opts.mapType
+func renderChunk(chunk *mapper.Chunk, opts *renderOptions) (chunkImage, error) {
+	switch opts.mapType {
+	case "biomes":
+		return genBiomesImage(chunk, opts)
+	case "terrain":
+		return genTerrainImage(chunk, opts)
+	case "slice":
+		return genSliceImage(chunk, opts)
+	case "caves":
+		return genCavesImage(chunk, opts)
+	case "height":
+		return genHeightImage(chunk)
+	case "isometric":
+		return genIsometricImage(chunk, opts)
 	}
-	return chunkImage{c.X(), c.Z(), img}
+	return chunkImage{}, fmt.Errorf("%s: invalid type", opts.mapType)
 }
 
-func biomeColour(id byte) color.RGBA {
-	switch id {
-	case 1:
-		return color.RGBA{0, 255, 127, 255} // Plains (Spring Green)
-	case 3:
-		return color.RGBA{152, 251, 152, 255} // Extreme Hills (Pale Green)
-	case 4, 18:
-		return color.RGBA{34, 139, 34, 255} // Forest [Hills] (Forest Green)
-	case 5:
-		return color.RGBA{0, 128, 0, 255} // Taiga (Green)
-	case 6:
-		return color.RGBA{107, 142, 35, 255} // Swampland (OliveDrab)
-	case 7:
-		return color.RGBA{0, 0, 205, 255} // River (MediumBlue)
-	case 29:
-		return color.RGBA{34, 139, 34, 255} // Roofed Forest (ForestGreen)
-	case 34:
-		return color.RGBA{255, 250, 250, 255} // Extreme Hills+ (Snow)
-	case 131:
-		return color.RGBA{220, 220, 220, 255} // Extreme Hills M (Gainsboro)
-	case 133:
-		return color.RGBA{34, 139, 34, 255} // Taiga M (Sea Green)
-	case 157:
-		return color.RGBA{85, 107, 47, 255} // Roofed Forest M (DarkOliveGreen)
-	default:
-		panic(fmt.Sprintf("unhandled biome: %d", id))
+func must(err error) {
+	if err != nil {
+		log.Fatal(err)
 	}
 }
@@ -0,0 +1,394 @@
+package main
+
+import "context"
+import "encoding/json"
+import "flag"
+import "fmt"
+import "hash/fnv"
+import "image"
+import "log"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+import "sync"
+
+import "github.com/timocp/mapper"
+
+// the version of the tile layout, in case manifests from older versions of
+// the program need ignoring
+const manifestVersion = 2
+
+// what was rendered into a tile directory, so that the next run can tell
+// which tiles have changed since
+type manifest struct {
+	Options string                `json:"options"` // see renderFingerprint
+	Zoom    int                   `json:"zoom"`
+	Tiles   map[string]*tileState `json:"tiles"` // by tileName
+}
+
+// what the region headers said about the chunks on a native tile, as sums of
+// chunkHash, so that saving, creating or deleting any of them changes it
+type tileState struct {
+	Chunks uint64 `json:"chunks"`
+	// of the chunks on its north, east, south and west edges, which its
+	// neighbours' shading looks at
+	Edges [4]uint64 `json:"edges"`
+}
+
+func tileName(k tileKey) string {
+	return fmt.Sprintf("%d,%d", k[0], k[1])
+}
+
+func parseTileName(name string) (k tileKey, err error) {
+	_, err = fmt.Sscanf(name, "%d,%d", &k[0], &k[1])
+	return
+}
+
+func chunkHash(x int, z int, timestamp int64) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d,%d,%d", x, z, timestamp)
+	return h.Sum64()
+}
+
+// the native tile which chunk x z is drawn on in top-down maps
+func chunkTile(x int, z int) tileKey {
+	return tileKey{floorDiv(x*16, tileSize), floorDiv(z*16, tileSize)}
+}
+
+// returns the manifest in dir, or nil if there isn't a usable one
+func readManifest(dir string) *manifest {
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		return nil
+	}
+	m := new(manifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		log.Printf("%s: ignoring manifest: %s", dir, err)
+		return nil
+	}
+	return m
+}
+
+func (m *manifest) save(dir string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "manifest.json"), data, 0644)
+}
+
+// describes the flags which change how chunks look (all of flags apart from
+// skip), so a tile directory rendered differently is rendered again from
+// scratch.  Changes to the contents of palette or resource pack files aren't
+// noticed; use -full
+func renderFingerprint(flags *flag.FlagSet, skip ...string) string {
+	var opts []string
+	flags.VisitAll(func(f *flag.Flag) {
+		for _, name := range skip {
+			if f.Name == name {
+				return
+			}
+		}
+		opts = append(opts, f.Name+"="+f.Value.String())
+	})
+	sort.Strings(opts)
+	return fmt.Sprintf("v%d %s", manifestVersion, strings.Join(opts, " "))
+}
+
+// calls each with the world chunk x z and timestamp of every chunk in the
+// region files, a region at a time, from their headers and without decoding
+// any of them
+func scanChunks(files []string, each func(x int, z int, timestamp int64)) {
+	for _, fn := range files {
+		r := new(mapper.Region)
+		if err := r.Open(fn); err != nil {
+			log.Printf("%s: %s", fn, err)
+			continue
+		}
+		infos, err := r.Chunks()
+		if err != nil {
+			log.Printf("%s: %s", fn, err)
+		}
+		for _, info := range infos {
+			each(r.X*32+info.X, r.Z*32+info.Z, info.Timestamp.Unix())
+		}
+		r.Close()
+	}
+}
+
+// the world chunk coordinates which the chunks of the region files cover,
+// from their headers, and how many chunks there are
+func scanExtent(files []string) (extent image.Rectangle, count int) {
+	scanChunks(files, func(x int, z int, timestamp int64) {
+		r := image.Rect(x, z, x+1, z+1)
+		if count == 0 {
+			extent = r
+		} else {
+			extent = extent.Union(r)
+		}
+		count++
+	})
+	return
+}
+
+// which tiles of a tile directory need rendering
+type tilePlan struct {
+	dir      string
+	old      *manifest // nil if there wasn't one
+	manifest *manifest
+	// true if every tile is rendered again, and tiles from the last run
+	// are removed first
+	full bool
+	// the native tiles to render.  For isometric maps this isn't known
+	// until they're drawn
+	dirty map[tileKey]bool
+	// true if shading, which looks at the chunks around each dirty tile
+	border bool
+	centre image.Point // of the map, in blocks
+}
+
+// compares the region headers with the manifest of the last run into dir.
+// If the options and extent of the map are the same, only tiles with chunks
+// which have been saved, created or deleted since are dirty.  Isometric
+// maps, where chunks overlap many tiles, are always rendered in full.  When
+// shading, a chunk on the edge of a tile changes the shading of the tiles it
+// touches too, so they're dirty as well.  This needs memory for each tile,
+// but not each chunk
+func planTiles(dir string, files []string, opts *renderOptions, full bool) *tilePlan {
+	p := &tilePlan{dir: dir, old: readManifest(dir), dirty: make(map[tileKey]bool), border: opts.shading != nil}
+	p.manifest = &manifest{Options: renderFingerprint(flag.CommandLine, "tiles", "full", "workers"), Tiles: make(map[string]*tileState)}
+	var tiles []tileKey
+	var sumx, sumz, count int64
+	scanChunks(files, func(x int, z int, timestamp int64) {
+		k := chunkTile(x, z)
+		st, ok := p.manifest.Tiles[tileName(k)]
+		if !ok {
+			st = new(tileState)
+			p.manifest.Tiles[tileName(k)] = st
+			tiles = append(tiles, k)
+		}
+		h := chunkHash(x, z, timestamp)
+		st.Chunks += h
+		// the chunk's place on the tile
+		cx, cz := x-k[0]*tileSize/16, z-k[1]*tileSize/16
+		for i, edge := range []bool{cz == 0, cx == tileSize/16-1, cz == tileSize/16-1, cx == 0} {
+			if edge {
+				st.Edges[i] += h
+			}
+		}
+		sumx += int64(x*16 + 8)
+		sumz += int64(z*16 + 8)
+		count++
+	})
+	if count > 0 {
+		p.centre = image.Point{int(sumx / count), int(sumz / count)}
+	}
+	p.manifest.Zoom = nativeZoom(tiles)
+	if full || opts.mapType == "isometric" || p.old == nil ||
+		p.old.Options != p.manifest.Options || p.old.Zoom != p.manifest.Zoom {
+		p.full = true
+		if opts.mapType != "isometric" {
+			for _, k := range tiles {
+				p.dirty[k] = true
+			}
+		}
+		return p
+	}
+	// tile k is dirty, and so are the tiles next to the edges listed
+	changed := func(k tileKey, edges [4]bool) {
+		p.dirty[k] = true
+		if !p.border {
+			return
+		}
+		next := [4]tileKey{{k[0], k[1] - 1}, {k[0] + 1, k[1]}, {k[0], k[1] + 1}, {k[0] - 1, k[1]}}
+		for i, edge := range edges {
+			if !edge {
+				continue
+			}
+			p.dirty[next[i]] = true
+			// both edges at a corner changing may mean its chunk did
+			if j := (i + 1) % 4; edges[j] {
+				p.dirty[tileKey{next[i][0] + next[j][0] - k[0], next[i][1] + next[j][1] - k[1]}] = true
+			}
+		}
+	}
+	all := [4]bool{true, true, true, true}
+	for _, k := range tiles {
+		st := p.manifest.Tiles[tileName(k)]
+		old, ok := p.old.Tiles[tileName(k)]
+		if !ok {
+			changed(k, all)
+		} else if *old != *st {
+			var edges [4]bool
+			for i := range edges {
+				edges[i] = old.Edges[i] != st.Edges[i]
+			}
+			changed(k, edges)
+		}
+	}
+	for name := range p.old.Tiles {
+		if _, ok := p.manifest.Tiles[name]; !ok {
+			if k, err := parseTileName(name); err == nil {
+				changed(k, all)
+			}
+		}
+	}
+	fmt.Printf("%d tiles changed since the last run\n", len(p.dirty))
+	return p
+}
+
+// true if chunk x z is on a tile which needs rendering or, when shading, is
+// next to one
+func (p *tilePlan) wants(x int, z int) bool {
+	if p.full || p.dirty[chunkTile(x, z)] {
+		return true
+	}
+	if p.border {
+		for dz := -1; dz <= 1; dz++ {
+			for dx := -1; dx <= 1; dx++ {
+				if p.dirty[chunkTile(x+dx, z+dz)] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// renders the dirty tiles and writes them, then the tiles covering them at
+// each zoom level out, an index.html and the manifest
+func (p *tilePlan) write(ctx context.Context, files []string, opts *renderOptions, info *mapper.LevelInfo, text map[string]string) error {
+	if p.full {
+		// so that if this run is stopped, the next doesn't think the
+		// tiles removed below are still there
+		if err := os.Remove(filepath.Join(p.dir, "manifest.json")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if p.full && p.old != nil {
+		// start again, so there are no tiles left from an earlier map
+		for z := 0; z <= p.old.Zoom; z++ {
+			if err := os.RemoveAll(filepath.Join(p.dir, fmt.Sprint(z))); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+	// tiles with chunks which fail are left out of the manifest, so that
+	// the next run tries them again
+	var mu sync.Mutex
+	o := *opts
+	o.failed = func(x int, z int) {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(p.manifest.Tiles, tileName(chunkTile(x, z)))
+	}
+	opts = &o
+	var written int
+	var err error
+	if opts.mapType == "isometric" {
+		written, err = p.writeIsometric(ctx, files, opts, text)
+	} else {
+		written, err = p.writeFlat(ctx, files, opts, info, text)
+	}
+	if err != nil {
+		return err
+	}
+	n, err := writePyramid(p.dir, p.manifest.Zoom, p.dirty, text)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d tiles (zoom 0-%d) to %s\n", written+n, p.manifest.Zoom, p.dir)
+	if err := writeIndex(p.dir, p.manifest.Zoom, p.centre, opts.mapType != "isometric", text); err != nil {
+		return err
+	}
+	return p.manifest.save(p.dir)
+}
+
+// renders and writes the dirty native tiles of a top-down map a row of tiles
+// at a time, so memory use depends on the width of the map but not its
+// height.  When shading, the chunk rows either side of each row of tiles are
+// rendered too, so that its edges are shaded like the rest
+func (p *tilePlan) writeFlat(ctx context.Context, files []string, opts *renderOptions, info *mapper.LevelInfo, text map[string]string) (int, error) {
+	rows := make(map[int]map[tileKey]bool)
+	for k := range p.dirty {
+		if rows[k[1]] == nil {
+			rows[k[1]] = make(map[tileKey]bool)
+		}
+		rows[k[1]][k] = true
+	}
+	var order []int
+	for ty := range rows {
+		order = append(order, ty)
+	}
+	sort.Ints(order)
+	regions := make(map[int][]string)
+	for _, fn := range files {
+		if _, z, ok := regionCoords(fn); ok {
+			regions[z] = append(regions[z], fn)
+		}
+	}
+	border := 0
+	if p.border {
+		border = 1
+	}
+	written, count := 0, 0
+	for _, ty := range order {
+		// the chunk rows to render, top to bottom-1
+		top, bottom := ty*tileSize/16-border, (ty+1)*tileSize/16+border
+		var rowFiles []string
+		for rz := floorDiv(top, 32); rz <= floorDiv(bottom-1, 32); rz++ {
+			rowFiles = append(rowFiles, regions[rz]...)
+		}
+		row := *opts
+		row.only = func(x int, z int) bool {
+			return z >= top && z < bottom && p.wants(x, z)
+		}
+		var images []chunkImage
+		err := renderRegions(ctx, rowFiles, &row, func(ci chunkImage) {
+			if chunkTile(ci.x, ci.z)[1] == ty {
+				count++
+			}
+			images = append(images, ci)
+		})
+		if err != nil {
+			return written, err
+		}
+		n, err := writeTiles(p.dir, p.manifest.Zoom, flatTiles(images, opts, info), rows[ty], text)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	fmt.Printf("imaged %d chunks\n", count)
+	return written, nil
+}
+
+// renders an isometric map and writes its native tiles, which are all dirty.
+// The map is put together from all of its chunks at once, so is refused if
+// it's too big
+func (p *tilePlan) writeIsometric(ctx context.Context, files []string, opts *renderOptions, text map[string]string) (int, error) {
+	extent, _ := scanExtent(files)
+	if err := checkIsometricSize(extent, opts.rotation); err != nil {
+		return 0, err
+	}
+	var images []chunkImage
+	err := renderRegions(ctx, files, opts, func(ci chunkImage) {
+		images = append(images, ci)
+	})
+	if err != nil {
+		return 0, err
+	}
+	fmt.Printf("imaged %d chunks\n", len(images))
+	tiles := imageTiles(composeIsometric(images))
+	var keys []tileKey
+	for k := range tiles {
+		keys = append(keys, k)
+		p.dirty[k] = true
+	}
+	p.manifest.Zoom = nativeZoom(keys)
+	return writeTiles(p.dir, p.manifest.Zoom, tiles, p.dirty, text)
+}
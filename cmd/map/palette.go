@@ -0,0 +1,96 @@
+package main
+
+import "fmt"
+import "os"
+import "path/filepath"
+import "strings"
+
+import "github.com/timocp/mapper"
+
+// returns the palette made from the comma separated list of packs with the
+// colours of the palette file user over it.  Returns nil if none are given
+func loadPalette(packs string, cache string, user string) (*mapper.Palette, error) {
+	p, err := loadTexturePalette(packs, cache)
+	if err != nil || user == "" {
+		return p, err
+	}
+	u, err := mapper.ReadPalette(user)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return u, nil
+	}
+	p.Merge(u)
+	return p, nil
+}
+
+// returns the palette made from the comma separated list of packs, reading it
+// from cache if it was made from the same packs (in the same order, and
+// unchanged since), and otherwise writing it there.  Returns nil if neither
+// is given
+func loadTexturePalette(packs string, cache string) (*mapper.Palette, error) {
+	var fns []string
+	if packs != "" {
+		fns = strings.Split(packs, ",")
+	}
+	source := packsSource(fns)
+	if cache != "" {
+		p, err := mapper.ReadPalette(cache)
+		if err == nil && (len(fns) == 0 || p.Source == source) {
+			return p, nil
+		} else if len(fns) == 0 {
+			return nil, fmt.Errorf("-palette-cache %s: %s", cache, err)
+		}
+	}
+	if len(fns) == 0 {
+		return nil, nil
+	}
+	fmt.Printf("Reading textures from %s\n", packs)
+	p, err := mapper.LoadResourcePack(fns...)
+	if err != nil {
+		return nil, err
+	}
+	if cache != "" {
+		p.Source = source
+		if err := p.Save(cache); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// describes the packs, in order, by path, size and modification time, so a
+// cached palette can tell whether it was made from them.  Packs which can't
+// be found are described as such, so a palette isn't cached as them
+func packsSource(fns []string) string {
+	var packs []string
+	for _, fn := range fns {
+		abs, err := filepath.Abs(fn)
+		if err != nil {
+			abs = fn
+		}
+		fi, err := os.Stat(fn)
+		if err != nil {
+			packs = append(packs, abs+" missing")
+			continue
+		}
+		packs = append(packs, fmt.Sprintf("%s %d %d", abs, fi.Size(), fi.ModTime().UnixNano()))
+	}
+	return strings.Join(packs, "; ")
+}
+
+// returns a Tinter using the colormaps from the comma separated list of
+// colormaps, or if that's empty from the packs if they have them.  Returns
+// nil, for the built in colours, if neither is given
+func loadColormaps(colormaps string, packs string) (*mapper.Tinter, error) {
+	if colormaps != "" {
+		return mapper.LoadColormaps(strings.Split(colormaps, ",")...)
+	}
+	if packs != "" {
+		if t, err := mapper.LoadColormaps(strings.Split(packs, ",")...); err == nil {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import "bufio"
+import "bytes"
+import "compress/zlib"
+import "encoding/binary"
+import "fmt"
+import "hash/crc32"
+import "image"
+import "image/png"
+import "io"
+import "sort"
+
+// encodes img as a PNG with a text chunk for each entry of text (eg "Title").
+// image/png can't write text chunks, so they are spliced in after the IHDR
+// chunk.  They're iTXt rather than tEXt chunks because world names may not be
+// Latin-1
+func encodePNG(w io.Writer, img image.Image, text map[string]string) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	encoded := buf.Bytes()
+	// 8 byte signature, then IHDR: length, type, 13 bytes of data, crc
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4
+	if _, err := w.Write(encoded[:ihdrEnd]); err != nil {
+		return err
+	}
+	if err := writePNGText(w, text); err != nil {
+		return err
+	}
+	_, err := w.Write(encoded[ihdrEnd:])
+	return err
+}
+
+func writePNGText(w io.Writer, text map[string]string) error {
+	var keys []string
+	for k := range text {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		// keyword, then uncompressed with no language or translated keyword
+		if err := writePNGChunk(w, "iTXt", []byte(k+"\x00\x00\x00\x00\x00"+text[k])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePNGChunk(w io.Writer, kind string, data []byte) error {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk[0:4], uint32(len(data)))
+	copy(chunk[4:8], kind)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	_, err := w.Write(chunk)
+	return err
+}
+
+// writes a PNG a few rows at a time, for images too big to hold in memory.
+// Pixels are 8 bit RGBA
+type pngWriter struct {
+	w      io.Writer
+	width  int
+	height int
+	rows   int // written so far
+	buf    *bufio.Writer
+	z      *zlib.Writer
+	// the previous row and a candidate for each filter type, unpremultiplied,
+	// with the filter type byte first
+	prev    []byte
+	filters [5][]byte
+}
+
+// the IDAT chunks are the zlib stream split wherever it's flushed
+type idatWriter struct {
+	w io.Writer
+}
+
+func (i idatWriter) Write(data []byte) (int, error) {
+	if err := writePNGChunk(i.w, "IDAT", data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// writes the header of a width x height PNG with text chunks to w.  Exactly
+// height rows must then be written before Close
+func newPNGWriter(w io.Writer, width int, height int, text map[string]string) (*pngWriter, error) {
+	if _, err := io.WriteString(w, "\x89PNG\r\n\x1a\n"); err != nil {
+		return nil, err
+	}
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(height))
+	ihdr[8] = 8 // bits per sample
+	ihdr[9] = 6 // truecolour with alpha
+	if err := writePNGChunk(w, "IHDR", ihdr); err != nil {
+		return nil, err
+	}
+	if err := writePNGText(w, text); err != nil {
+		return nil, err
+	}
+	p := &pngWriter{w: w, width: width, height: height, prev: make([]byte, 1+width*4)}
+	p.buf = bufio.NewWriterSize(idatWriter{w}, 1<<16)
+	p.z = zlib.NewWriter(p.buf)
+	for i := range p.filters {
+		p.filters[i] = make([]byte, 1+width*4)
+		p.filters[i][0] = byte(i)
+	}
+	return p, nil
+}
+
+// writes every row of img, which must be as wide as the PNG
+func (p *pngWriter) WriteRows(img *image.RGBA) error {
+	b := img.Bounds()
+	if b.Dx() != p.width {
+		return fmt.Errorf("png: row width %d != %d", b.Dx(), p.width)
+	}
+	if p.rows+b.Dy() > p.height {
+		return fmt.Errorf("png: more than %d rows", p.height)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cur := p.filters[0]
+		copy(cur[1:], img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)])
+		for i := 1; i < len(cur); i += 4 {
+			// PNG colours aren't premultiplied
+			if a := int(cur[i+3]); a != 0 && a != 255 {
+				for j := i; j < i+3; j++ {
+					cur[j] = uint8(int(cur[j]) * 255 / a)
+				}
+			}
+		}
+		if _, err := p.z.Write(p.filter()); err != nil {
+			return err
+		}
+		copy(p.prev, cur)
+		p.rows++
+	}
+	return nil
+}
+
+// picks the filter for the row in filters[0] which gives the smallest sum
+// of absolute differences, as image/png does
+func (p *pngWriter) filter() []byte {
+	cur, prev := p.filters[0], p.prev
+	abs := func(v uint8) int {
+		if v < 128 {
+			return int(v)
+		}
+		return 256 - int(v)
+	}
+	best, bestSum := 0, 0
+	for i := 1; i < len(cur); i++ {
+		bestSum += abs(cur[i])
+	}
+	for f := 1; f < 5; f++ {
+		out, sum := p.filters[f], 0
+		for i := 1; i < len(cur); i++ {
+			var a, c uint8 // left and upper left
+			if i > 4 {
+				a, c = cur[i-4], prev[i-4]
+			}
+			b := prev[i]
+			switch f {
+			case 1: // sub
+				out[i] = cur[i] - a
+			case 2: // up
+				out[i] = cur[i] - b
+			case 3: // average
+				out[i] = cur[i] - uint8((int(a)+int(b))/2)
+			case 4:
+				out[i] = cur[i] - paeth(a, b, c)
+			}
+			sum += abs(out[i])
+		}
+		if sum < bestSum {
+			best, bestSum = f, sum
+		}
+	}
+	return p.filters[best]
+}
+
+func paeth(a uint8, b uint8, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := p-int(a), p-int(b), p-int(c)
+	if pa < 0 {
+		pa = -pa
+	}
+	if pb < 0 {
+		pb = -pb
+	}
+	if pc < 0 {
+		pc = -pc
+	}
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// finishes the image data and writes the end of the PNG
+func (p *pngWriter) Close() error {
+	if p.rows != p.height {
+		return fmt.Errorf("png: %d of %d rows written", p.rows, p.height)
+	}
+	if err := p.z.Close(); err != nil {
+		return err
+	}
+	if err := p.buf.Flush(); err != nil {
+		return err
+	}
+	return writePNGChunk(p.w, "IEND", nil)
+}
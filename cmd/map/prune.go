@@ -0,0 +1,101 @@
+package main
+
+import "flag"
+import "fmt"
+import "log"
+import "os"
+import "strconv"
+import "strings"
+import "time"
+
+import "github.com/timocp/mapper"
+
+// map prune [options] regionfile...
+//
+// removes chunks matching all of the given options from each region file
+func pruneMain(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	optInhabited := flags.Int64("inhabited", -1, "prune chunks players have spent fewer than this many ticks in")
+	optBox := flags.String("outside", "", "prune chunks entirely outside the block coordinates x1,z1,x2,z2")
+	optBefore := flags.String("before", "", "prune chunks last saved before this date (YYYY-MM-DD)")
+	optDryRun := flags.Bool("n", false, "only list the chunks which would be pruned")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s prune [options] regionfile...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	var preds []mapper.PrunePredicate
+	if *optInhabited >= 0 {
+		preds = append(preds, mapper.InhabitedBelow(*optInhabited))
+	}
+	if *optBox != "" {
+		box, err := parseInts(*optBox, 4)
+		must(err)
+		preds = append(preds, mapper.OutsideBox(box[0], box[1], box[2], box[3]))
+	}
+	if *optBefore != "" {
+		t, err := time.ParseInLocation("2006-01-02", *optBefore, time.Local)
+		must(err)
+		preds = append(preds, mapper.OlderThan(t))
+	}
+	if len(preds) == 0 {
+		// refuse to prune everything
+		flags.Usage()
+		os.Exit(2)
+	}
+	pred := mapper.AllOf(preds...)
+	total := 0
+	for _, fn := range flags.Args() {
+		if *optDryRun {
+			// errors are handled as by a real run, which skips the
+			// file
+			r := new(mapper.Region)
+			if err := r.Open(fn); err != nil {
+				log.Printf("%s: %s", fn, err)
+				continue
+			}
+			matched, _, err := mapper.MatchingChunks(r, pred)
+			r.Close()
+			if err != nil {
+				log.Printf("%s: %s", fn, err)
+				continue
+			}
+			for _, info := range matched {
+				fmt.Printf("%s: would prune chunk %d,%d\n", fn, r.X*32+info.X, r.Z*32+info.Z)
+			}
+			total += len(matched)
+			continue
+		}
+		removed, err := mapper.Prune(fn, pred)
+		if err != nil {
+			log.Printf("%s: %s", fn, err)
+			continue
+		}
+		if removed > 0 {
+			fmt.Printf("%s: pruned %d chunks\n", fn, removed)
+		}
+		total += removed
+	}
+	if *optDryRun {
+		fmt.Printf("would prune %d chunks\n", total)
+	} else {
+		fmt.Printf("pruned %d chunks\n", total)
+	}
+}
+
+// parses a comma separated list of exactly n integers
+func parseInts(s string, n int) ([]int, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("%q: want %d comma separated numbers", s, n)
+	}
+	result := make([]int, n)
+	for i, f := range fields {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import "image"
+import "image/color"
+
+import "github.com/timocp/mapper"
+
+// colours each column by the biome at its surface
+func genBiomesImage(c *mapper.Chunk, opts *renderOptions) (chunkImage, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	top, bottom, err := searchRange(c, opts)
+	if err != nil {
+		return chunkImage{}, err
+	}
+	for x := 0; x < 16; x++ {
+		for z := 0; z < 16; z++ {
+			y, _, err := findSurface(c, opts, x, z, top, bottom)
+			if err != nil {
+				return chunkImage{}, err
+			}
+			biome, err := c.Biome(x, y, z)
+			if err != nil {
+				return chunkImage{}, err
+			}
+			if biome == nil {
+				continue
+			}
+			if colour, ok := opts.palette.BiomeColour(biome); ok {
+				img.Set(x, z, colour)
+			} else {
+				img.Set(x, z, biome.Colour)
+			}
+		}
+	}
+	return chunkImage{x: c.X(), z: c.Z(), img: img}, nil
+}
+
+// return a 16x16 chunkImage where brightness is relative to height
+func genHeightImage(c *mapper.Chunk) (chunkImage, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	heights, err := c.HeightMap()
+	if err != nil {
+		return chunkImage{}, err
+	}
+	// the height of the world, rather than of this chunk's sections, so
+	// that chunks next to each other agree: 0-255 before 1.18, and 384
+	// blocks from its bottom since
+	minSection, err := c.MinSection()
+	if err != nil {
+		return chunkImage{}, err
+	}
+	bottom, top := 0, 255
+	if minSection < 0 {
+		bottom, top = minSection*16, minSection*16+383
+	}
+	for i, v := range heights {
+		grey := heightGrey(int(v), bottom, top)
+		img.Set(i%16, i/16, color.RGBA{grey, grey, grey, 255})
+	}
+	return chunkImage{x: c.X(), z: c.Z(), img: img}, nil
+}
+
+// scales y from bottom to top into the range of a colour channel
+func heightGrey(y int, bottom int, top int) uint8 {
+	if y <= bottom {
+		return 0
+	}
+	if y >= top {
+		return 255
+	}
+	return uint8((y - bottom) * 255 / (top - bottom))
+}
+
+// returns the range of Y to look for the surface of c in: from the top of
+// its highest section (or the ceiling if that's lower) down to the bottom of
+// its lowest
+func searchRange(c *mapper.Chunk, opts *renderOptions) (top int, bottom int, err error) {
+	// start looking at top of highest in-chunk section
+	// don't use heightmap, because that is about max light
+	maxSection, err := c.MaxSection()
+	if err != nil {
+		return
+	}
+	top = maxSection*16 + 15
+	ceiling := opts.ceiling
+	if ceiling == unsetY && opts.dimension == mapper.Nether {
+		ceiling = netherCeiling
+	}
+	if ceiling != unsetY && ceiling < top {
+		top = ceiling
+	}
+	// since 1.18 the world extends below 0
+	minSection, err := c.MinSection()
+	bottom = minSection * 16
+	return
+}
+
+// returns the first block other than air going down from top at x z.  In the
+// nether, where top is probably inside the netherrack under the roof, it's
+// the first block below some air.  The result is air if there's nothing
+// there
+func findSurface(c *mapper.Chunk, opts *renderOptions, x int, z int, top int, bottom int) (y int, block mapper.Block, err error) {
+	y = top
+	block, err = c.BlockAt(x, y, z)
+	if opts.dimension == mapper.Nether {
+		// go down until we find the air of a cave
+		for err == nil && block.Id != mapper.Air && y > bottom {
+			y--
+			block, err = c.BlockAt(x, y, z)
+		}
+	}
+	// go down until we find something other than air
+	for err == nil && block.Id == mapper.Air && y > bottom {
+		y--
+		block, err = c.BlockAt(x, y, z)
+	}
+	return
+}
+
+func genTerrainImage(c *mapper.Chunk, opts *renderOptions) (chunkImage, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	//fmt.Printf("x=%d z=%d\n", c.X(), c.Z())
+	top, bottom, err := searchRange(c, opts)
+	if err != nil {
+		return chunkImage{}, err
+	}
+	heights := make([]int, 256)
+	for x := 0; x < 16; x++ {
+		for z := 0; z < 16; z++ {
+			y, block, err := findSurface(c, opts, x, z, top, bottom)
+			if err != nil {
+				return chunkImage{}, err
+			}
+			heights[z*16+x] = y
+			//fmt.Printf("%d.%d.%d is %s\n", c.X()*16+x, y, c.Z()*16+z, block.Name())
+			if block.Id == mapper.Air && opts.dimension == mapper.End {
+				img.Set(x, z, endVoid)
+				continue
+			}
+			colour, err := blockColour(c, opts, block, x, y, z)
+			if err != nil {
+				return chunkImage{}, err
+			}
+			img.Set(x, z, colour)
+		}
+	}
+	return chunkImage{x: c.X(), z: c.Z(), img: img, heights: heights}, nil
+}
+
+// the colour of block, which is at x y z of c, tinted by its biome if that's
+// turned on
+func blockColour(c *mapper.Chunk, opts *renderOptions, block mapper.Block, x int, y int, z int) (color.RGBA, error) {
+	if !opts.tint || mapper.BlockTint(block) == mapper.TintNone {
+		return opts.palette.Colour(block), nil
+	}
+	biome, err := c.Biome(x, y, z)
+	if err != nil {
+		return color.RGBA{}, err
+	}
+	return opts.tinter.BlockColour(opts.palette, block, biome), nil
+}
+
+// the colour of air in slice maps, so that tunnels show up
+var sliceAir = color.RGBA{40, 40, 40, 255}
+
+// the blocks at exactly opts.level, or if that's not given the terrain below
+// opts.ceiling
+func genSliceImage(c *mapper.Chunk, opts *renderOptions) (chunkImage, error) {
+	if opts.level == unsetY {
+		return genTerrainImage(c, opts)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := 0; x < 16; x++ {
+		for z := 0; z < 16; z++ {
+			block, err := c.BlockAt(x, opts.level, z)
+			if err != nil {
+				return chunkImage{}, err
+			}
+			if block.Id == mapper.Air {
+				img.Set(x, z, sliceAir)
+				continue
+			}
+			colour, err := blockColour(c, opts, block, x, opts.level, z)
+			if err != nil {
+				return chunkImage{}, err
+			}
+			img.Set(x, z, colour)
+		}
+	}
+	return chunkImage{x: c.X(), z: c.Z(), img: img}, nil
+}
+
+// colours for cave maps: solid ground, and a gradient from a little to a lot
+// of air below the surface
+var (
+	caveNone = color.RGBA{32, 32, 32, 255}
+	caveFew  = color.RGBA{60, 60, 160, 255}
+	caveMany = color.RGBA{255, 220, 0, 255}
+)
+
+// the number of blocks of cave air in a column which gets the caveMany colour
+const caveFull = 64
+
+// highlights columns with air pockets below the surface; the more air, the
+// brighter
+func genCavesImage(c *mapper.Chunk, opts *renderOptions) (chunkImage, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	top, bottom, err := searchRange(c, opts)
+	if err != nil {
+		return chunkImage{}, err
+	}
+	for x := 0; x < 16; x++ {
+		for z := 0; z < 16; z++ {
+			y, _, err := findSurface(c, opts, x, z, top, bottom)
+			if err != nil {
+				return chunkImage{}, err
+			}
+			air := 0
+			for y--; y >= bottom; y-- {
+				block, err := c.BlockAt(x, y, z)
+				if err != nil {
+					return chunkImage{}, err
+				}
+				if block.Id == mapper.Air {
+					air++
+				}
+			}
+			if air == 0 {
+				img.Set(x, z, caveNone)
+			} else {
+				img.Set(x, z, lerpColour(caveFew, caveMany, float64(air)/caveFull))
+			}
+		}
+	}
+	return chunkImage{x: c.X(), z: c.Z(), img: img}, nil
+}
+
+// returns the colour t (clamped to 0..1) of the way from a to b
+func lerpColour(a color.RGBA, b color.RGBA, t float64) color.RGBA {
+	if t > 1 {
+		t = 1
+	} else if t < 0 {
+		t = 0
+	}
+	lerp := func(a uint8, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*t)
+	}
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestHeightGrey(t *testing.T) {
+	for _, test := range []struct {
+		y, bottom, top int
+		want           uint8
+	}{
+		// before 1.18
+		{0, 0, 255, 0},
+		{64, 0, 255, 64},
+		{255, 0, 255, 255},
+		// since, where heights are below 0 or above 255
+		{-64, -64, 319, 0},
+		{-20, -64, 319, 29},
+		{63, -64, 319, 84},
+		{300, -64, 319, 242},
+		{319, -64, 319, 255},
+		// out of range
+		{-100, -64, 319, 0},
+		{400, -64, 319, 255},
+	} {
+		if got := heightGrey(test.y, test.bottom, test.top); got != test.want {
+			t.Errorf("heightGrey(%d, %d, %d) = %d, want %d", test.y, test.bottom, test.top, got, test.want)
+		}
+	}
+}
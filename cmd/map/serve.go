@@ -0,0 +1,332 @@
+package main
+
+import "context"
+import "crypto/sha1"
+import "errors"
+import "flag"
+import "fmt"
+import "image"
+import "log"
+import "net"
+import "net/http"
+import "os"
+import "os/signal"
+import "path/filepath"
+import "runtime"
+import "sync"
+import "time"
+
+import "github.com/timocp/mapper"
+
+// how many zoom levels out from the native one the server will render.  Each
+// level out covers four times as many chunks per tile
+const serveZoomOut = 4
+
+// renders the tiles of one dimension of a world as they're asked for, keeping
+// them in a cache directory until the region files under them change
+type tileServer struct {
+	dir   string // of the dimension's region files
+	opts  *renderOptions
+	info  *mapper.LevelInfo
+	cache string
+	zoom  int // of the tiles with a pixel per block
+	text  map[string]string
+	// shared by the renders of every tile, so that however many are asked
+	// for at once no more than opts.workers chunks are rendered at a time
+	limit chan struct{}
+	// held while a tile is being rendered, by cache filename, so that
+	// requests for the same tile wait for one render rather than each
+	// doing it.  Only tiles being asked for have one
+	mu    sync.Mutex
+	locks map[string]*tileLock
+}
+
+type tileLock struct {
+	sync.Mutex
+	users int // holding or waiting for it
+}
+
+// map serve [options] world
+//
+// serves a Leaflet map of a world over HTTP, rendering tiles when they're
+// first looked at and again after the chunks under them are saved
+func serveMain(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	newOptions := renderFlags(flags)
+	optAddr := flags.String("addr", "localhost:8080", "address to listen on")
+	optCache := flags.String("cache", "", "directory to keep rendered tiles in (default: mapper in the user cache directory)")
+	optWorkers := flags.Int("workers", runtime.NumCPU(), "number of chunks to render at once, across all the tiles being rendered")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s serve [options] world\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	opts := newOptions()
+	opts.workers = *optWorkers
+	if opts.workers <= 0 {
+		opts.workers = runtime.NumCPU()
+	}
+	if opts.mapType == "isometric" {
+		log.Fatal("serve can't draw isometric maps")
+	}
+	w, err := mapper.OpenWorld(flags.Arg(0))
+	must(err)
+	d, err := w.Dimension(opts.dimension)
+	must(err)
+	s := &tileServer{dir: d.Dir, opts: opts, info: w.Info, locks: make(map[string]*tileLock)}
+	s.limit = make(chan struct{}, opts.workers)
+	s.text = map[string]string{"Software": "mapper", "Title": w.Info.Name}
+	// a directory for each world and set of options, so that changing
+	// either doesn't show tiles rendered for the other
+	cache := *optCache
+	if cache == "" {
+		dir, err := os.UserCacheDir()
+		must(err)
+		cache = filepath.Join(dir, "mapper")
+	}
+	abs, err := filepath.Abs(d.Dir)
+	must(err)
+	hash := sha1.Sum([]byte(abs + "\n" + renderFingerprint(flags, "addr", "cache", "workers")))
+	s.cache = filepath.Join(cache, fmt.Sprintf("%x", hash[:8]))
+	var tiles []tileKey
+	var centre image.Point
+	files := d.RegionFiles()
+	for _, fn := range files {
+		x, z, ok := regionCoords(fn)
+		if !ok {
+			continue
+		}
+		// two tiles across each region
+		tiles = append(tiles, tileKey{x * 2, z * 2}, tileKey{x*2 + 1, z*2 + 1})
+		centre = centre.Add(image.Point{x*512 + 256, z*512 + 256})
+	}
+	if len(files) > 0 {
+		centre = centre.Div(len(files))
+	}
+	if opts.dimension == mapper.Overworld {
+		centre = image.Point{w.Info.SpawnX, w.Info.SpawnZ}
+	}
+	s.zoom = nativeZoom(tiles)
+	minZoom := s.zoom - serveZoomOut
+	if minZoom < 0 {
+		minZoom = 0
+	}
+	http.HandleFunc("/", func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(rw, req)
+			return
+		}
+		err := indexTemplate.Execute(rw, map[string]interface{}{
+			"Title":   w.Info.Name,
+			"TileURL": "tiles/{z}/{x}/{y}.png",
+			"Zoom":    s.zoom,
+			"MinZoom": minZoom,
+			"CentreX": centre.X,
+			"CentreZ": centre.Y,
+			"Coords":  true,
+		})
+		if err != nil {
+			log.Print(err)
+		}
+	})
+	http.HandleFunc("/tiles/", func(rw http.ResponseWriter, req *http.Request) {
+		var zoom int
+		var k tileKey
+		if _, err := fmt.Sscanf(req.URL.Path, "/tiles/%d/%d/%d.png", &zoom, &k[0], &k[1]); err != nil ||
+			zoom < minZoom || zoom > s.zoom {
+			http.NotFound(rw, req)
+			return
+		}
+		fn, err := s.tile(req.Context(), zoom, k)
+		if errors.Is(err, context.Canceled) {
+			// the tile isn't wanted any more, or we're stopping
+			return
+		} else if err != nil {
+			log.Printf("tile %d/%d/%d: %s", zoom, k[0], k[1], err)
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if fn == "" {
+			http.NotFound(rw, req)
+			return
+		}
+		// so that browsers ask again, and see chunks saved since
+		rw.Header().Set("Cache-Control", "no-cache")
+		http.ServeFile(rw, req, fn)
+	})
+	// on ^C, stop rendering and let requests finish
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	srv := &http.Server{
+		Addr: *optAddr,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	fmt.Printf("serving %s on http://%s/ (tiles in %s)\n", w.Dir, *optAddr, s.cache)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
+// locks the tile with cache filename fn, returning the function which
+// unlocks it
+func (s *tileServer) lock(fn string) func() {
+	s.mu.Lock()
+	l, ok := s.locks[fn]
+	if !ok {
+		l = new(tileLock)
+		s.locks[fn] = l
+	}
+	l.users++
+	s.mu.Unlock()
+	l.Lock()
+	return func() {
+		l.Unlock()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if l.users--; l.users == 0 {
+			delete(s.locks, fn)
+		}
+	}
+}
+
+// returns the cache filename of tile k at zoom, rendering it first if it
+// isn't there or is older than a region file under it.  Returns "" if there
+// is nothing drawn on the tile
+func (s *tileServer) tile(ctx context.Context, zoom int, k tileKey) (string, error) {
+	fn := tilePath(s.cache, zoom, k)
+	defer s.lock(fn)()
+	modified, ok := s.modified(zoom, k)
+	if !ok {
+		os.Remove(fn)
+		return "", nil
+	}
+	if fi, err := os.Stat(fn); err == nil && fi.ModTime().After(modified) {
+		return fn, nil
+	}
+	var t *image.RGBA
+	if zoom == s.zoom {
+		var err error
+		if t, err = s.render(ctx, k); err != nil {
+			return "", err
+		}
+	} else {
+		// from the four tiles under it, each of which may need rendering
+		out := make(tileSet)
+		for _, d := range []tileKey{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+			child := tileKey{k[0]*2 + d[0], k[1]*2 + d[1]}
+			cfn, err := s.tile(ctx, zoom+1, child)
+			if err != nil {
+				return "", err
+			}
+			if cfn == "" {
+				continue
+			}
+			if ct := readTile(cfn, child); ct != nil {
+				out.shrink(child, ct)
+			}
+		}
+		t = out[k]
+	}
+	if t == nil || empty(t) {
+		os.Remove(fn)
+		return "", nil
+	}
+	if err := writeTile(fn, t, s.text); err != nil {
+		return "", err
+	}
+	return fn, nil
+}
+
+// the time the region files under tile k at zoom were last written, or
+// false if there aren't any.  Tiles are rendered again when any chunk in
+// their regions is saved, which also notices chunks being pruned.  When
+// shading, the regions of the chunks around the tile count too, as render
+// looks at them
+func (s *tileServer) modified(zoom int, k tileKey) (modified time.Time, ok bool) {
+	blocks := tileSize << (s.zoom - zoom)
+	border := 0
+	if s.opts.shading != nil {
+		border = 16
+	}
+	min := image.Point{k[0]*blocks - border, k[1]*blocks - border}
+	max := image.Point{(k[0]+1)*blocks - 1 + border, (k[1]+1)*blocks - 1 + border}
+	for z := floorDiv(min.Y, 512); z <= floorDiv(max.Y, 512); z++ {
+		for x := floorDiv(min.X, 512); x <= floorDiv(max.X, 512); x++ {
+			fi, err := os.Stat(s.regionFile(x, z))
+			if err != nil {
+				continue
+			}
+			if x*512 <= max.X-border && (x+1)*512 > min.X+border &&
+				z*512 <= max.Y-border && (z+1)*512 > min.Y+border {
+				// only regions under the tile mean it has something
+				// on it
+				ok = true
+			}
+			if fi.ModTime().After(modified) {
+				modified = fi.ModTime()
+			}
+		}
+	}
+	return
+}
+
+// region files are looked for each time rather than taken from the
+// Dimension, as the world may be being saved to while it's served
+func (s *tileServer) regionFile(x int, z int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("r.%d.%d.mca", x, z))
+}
+
+// renders native tile k from its chunks.  When shading, the chunks around
+// the tile are rendered too, so that its edges are shaded like the rest
+func (s *tileServer) render(ctx context.Context, k tileKey) (*image.RGBA, error) {
+	border := 0
+	if s.opts.shading != nil {
+		border = 1
+	}
+	min := image.Point{k[0] * tileSize / 16, k[1] * tileSize / 16}
+	chunks := image.Rect(min.X-border, min.Y-border, min.X+tileSize/16+border, min.Y+tileSize/16+border)
+	var files []string
+	for z := floorDiv(chunks.Min.Y, 32); z <= floorDiv(chunks.Max.Y-1, 32); z++ {
+		for x := floorDiv(chunks.Min.X, 32); x <= floorDiv(chunks.Max.X-1, 32); x++ {
+			if _, err := os.Stat(s.regionFile(x, z)); err == nil {
+				files = append(files, s.regionFile(x, z))
+			}
+		}
+	}
+	var mu sync.Mutex
+	var images []chunkImage
+	p := &mapper.Pipeline{
+		Workers: s.opts.workers,
+		Limit:   s.limit,
+		Filter: func(x int, z int) bool {
+			return (image.Point{x, z}).In(chunks)
+		},
+		Error: func(err *mapper.ChunkError) {
+			log.Print(err)
+		},
+	}
+	err := p.Run(ctx, files, func(c *mapper.Chunk) error {
+		ci, err := renderChunk(c, s.opts)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		images = append(images, ci)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return flatTiles(images, s.opts, s.info)[k], nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import "image"
+import "math"
+
+// shaded relief: each pixel is lit according to the slope between its
+// neighbours' heights, and lightened or darkened by its own height
+type shading struct {
+	azimuth  float64 // degrees clockwise from north (-Z)
+	altitude float64 // degrees above the horizon
+}
+
+// how strongly height affects brightness, per block above or below sea level
+const (
+	seaLevel       = 63
+	heightContrast = 1.0 / 256
+)
+
+// the lowest the light is allowed, as the sine of its altitude
+const minLightY = 0.01
+
+// shades img in place.  height returns the Y of the pixel at x z (in img's
+// coordinates, but possibly outside it), or unsetY where nothing was drawn;
+// missing neighbours count as level ground
+func (s *shading) apply(img *image.RGBA, height func(x int, z int) int) {
+	az := s.azimuth * math.Pi / 180
+	alt := s.altitude * math.Pi / 180
+	// unit vector towards the light, with x east, z south and y up
+	lx, lz, ly := math.Sin(az)*math.Cos(alt), -math.Cos(az)*math.Cos(alt), math.Sin(alt)
+	if ly < minLightY {
+		// level ground would be unlit, and the factors below infinite
+		ly = minLightY
+	}
+	heightOr := func(x int, z int, def int) float64 {
+		if y := height(x, z); y != unsetY {
+			return float64(y)
+		}
+		return float64(def)
+	}
+	b := img.Bounds()
+	for z := b.Min.Y; z < b.Max.Y; z++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			y := height(x, z)
+			if y == unsetY {
+				continue
+			}
+			// slope from the neighbours either side
+			dx := (heightOr(x+1, z, y) - heightOr(x-1, z, y)) / 2
+			dz := (heightOr(x, z+1, y) - heightOr(x, z-1, y)) / 2
+			// the surface normal is (-dx, 1, -dz), normalised
+			n := math.Sqrt(dx*dx + dz*dz + 1)
+			lit := (-dx*lx - dz*lz + ly) / n
+			if lit < 0 {
+				lit = 0
+			}
+			// relative to level ground, which is left as it is
+			factor := lit / ly
+			factor *= 1 + float64(y-seaLevel)*heightContrast
+			img.SetRGBA(x, z, shadeColour(img.RGBAAt(x, z), factor))
+		}
+	}
+}
+
+func scale(v uint8, factor float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, float64(v)*factor)))
+}
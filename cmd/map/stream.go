@@ -0,0 +1,144 @@
+package main
+
+import "context"
+import "errors"
+import "fmt"
+import "image"
+import "image/draw"
+import "os"
+import "path/filepath"
+
+import "github.com/timocp/mapper"
+
+// one row of regions of a top-down map, with bounds in map pixel coordinates
+type strip struct {
+	img *image.RGBA
+	// the heights of its columns, or nil if not shading
+	heights []int
+}
+
+// the region x z of a region file, from its r.X.Z.mca name
+func regionCoords(fn string) (x int, z int, ok bool) {
+	_, err := fmt.Sscanf(filepath.Base(fn), "r.%d.%d.mca", &x, &z)
+	return x, z, err == nil
+}
+
+// writes a top-down map of the region files to fn as a PNG.  It's rendered a
+// row of regions at a time, each written out once the row after it (which
+// its shading looks at) has been rendered, so memory use depends on the
+// width of the map but not its height.  If ctx is cancelled fn is removed
+func streamFlat(ctx context.Context, fn string, files []string, opts *renderOptions, info *mapper.LevelInfo, text map[string]string) (err error) {
+	// the extent of the map, from the region headers
+	extent, n := scanExtent(files)
+	if n == 0 {
+		return errors.New("no chunks to map")
+	}
+	minx, maxx, minz, maxz := extent.Min.X, extent.Max.X-1, extent.Min.Y, extent.Max.Y-1
+	rows := make(map[int][]string)
+	for _, file := range files {
+		if _, z, ok := regionCoords(file); ok {
+			rows[z] = append(rows[z], file)
+		}
+	}
+	width, height := (maxx-minx+1)*16, (maxz-minz+1)*16
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(fn)
+		}
+	}()
+	out, err := newPNGWriter(f, width, height, text)
+	if err != nil {
+		return err
+	}
+	count := 0
+	// renders the row of regions at region z rz
+	render := func(rz int) (*strip, error) {
+		top, bottom := rz*512-minz*16, (rz+1)*512-minz*16
+		if top < 0 {
+			top = 0
+		}
+		if bottom > height {
+			bottom = height
+		}
+		s := &strip{img: image.NewRGBA(image.Rect(0, top, width, bottom))}
+		b := s.img.Bounds()
+		if opts.dimension == mapper.End && opts.mapType == "terrain" {
+			draw.Draw(s.img, b, &image.Uniform{endVoid}, image.Point{}, draw.Src)
+		}
+		if opts.shading != nil {
+			s.heights = make([]int, b.Dx()*b.Dy())
+			for i := range s.heights {
+				s.heights[i] = unsetY
+			}
+		}
+		err := renderRegions(ctx, rows[rz], opts, func(ci chunkImage) {
+			r := image.Rect((ci.x-minx)*16, (ci.z-minz)*16, (ci.x-minx)*16+16, (ci.z-minz)*16+16)
+			if !r.In(b) {
+				// saved since the headers were read
+				return
+			}
+			count++
+			draw.Draw(s.img, r, ci.img, image.Point{0, 0}, draw.Src)
+			if s.heights != nil && ci.heights != nil {
+				for z := 0; z < 16; z++ {
+					copy(s.heights[(r.Min.Y-b.Min.Y+z)*width+r.Min.X:], ci.heights[z*16:z*16+16])
+				}
+			}
+		})
+		return s, err
+	}
+	// shades, marks and writes s, between the last row of heights of the
+	// strip above it and the strip below it (either may be nil)
+	var above []int
+	finish := func(s *strip, below *strip) error {
+		b := s.img.Bounds()
+		if s.heights != nil {
+			opts.shading.apply(s.img, func(x int, z int) int {
+				if x < 0 || x >= width {
+					return unsetY
+				}
+				switch {
+				case z >= b.Min.Y && z < b.Max.Y:
+					return s.heights[(z-b.Min.Y)*width+x]
+				case z == b.Min.Y-1 && above != nil:
+					return above[x]
+				case z == b.Max.Y && below != nil:
+					return below.heights[x]
+				}
+				return unsetY
+			})
+			above = append(above[:0], s.heights[len(s.heights)-width:]...)
+		}
+		if info != nil && opts.dimension == mapper.Overworld {
+			// parts of the cross outside the strip aren't drawn
+			markSpawn(s.img, info.SpawnX-minx*16, info.SpawnZ-minz*16)
+		}
+		return out.WriteRows(s.img)
+	}
+	var cur *strip
+	for rz := floorDiv(minz, 32); rz <= floorDiv(maxz, 32); rz++ {
+		next, err := render(rz)
+		if err != nil {
+			return err
+		}
+		if cur != nil {
+			if err := finish(cur, next); err != nil {
+				return err
+			}
+		}
+		cur = next
+	}
+	if err := finish(cur, nil); err != nil {
+		return err
+	}
+	fmt.Printf("imaged %d chunks (x: %d..%d, z: %d..%d)\n", count, minx, maxx, minz, maxz)
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,342 @@
+package main
+
+import "fmt"
+import "html/template"
+import "image"
+import "image/color"
+import "image/draw"
+import "image/png"
+import "log"
+import "os"
+import "path/filepath"
+
+import "github.com/timocp/mapper"
+
+// the width and height of web map tiles, in pixels
+const tileSize = 256
+
+// the x y of a tile within its zoom level
+type tileKey [2]int
+
+// one zoom level of a pyramid of tiles.  Each tile's bounds are its pixel
+// coordinates at that zoom; tiles with nothing drawn on them are left out
+type tileSet map[tileKey]*image.RGBA
+
+// rounds towards negative infinity, for negative coordinates
+func floorDiv(a int, b int) int {
+	if a < 0 {
+		return -((-a + b - 1) / b)
+	}
+	return a / b
+}
+
+// returns the tile at k, creating it (filled with bg if that isn't nil) if
+// it doesn't exist
+func (ts tileSet) tile(k tileKey, bg color.Color) *image.RGBA {
+	t, ok := ts[k]
+	if !ok {
+		t = image.NewRGBA(image.Rect(k[0]*tileSize, k[1]*tileSize, (k[0]+1)*tileSize, (k[1]+1)*tileSize))
+		if bg != nil {
+			draw.Draw(t, t.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+		}
+		ts[k] = t
+	}
+	return t
+}
+
+// places the chunk images of a top-down map onto tiles with one pixel per
+// block, so that pixel coordinates are world x z.  This only needs memory
+// for the tiles which have chunks on them
+func flatTiles(images []chunkImage, opts *renderOptions, info *mapper.LevelInfo) tileSet {
+	tiles := make(tileSet)
+	heights := make(map[tileKey][]int)
+	var bg color.Color
+	if opts.dimension == mapper.End && opts.mapType == "terrain" {
+		bg = endVoid
+	}
+	for _, ci := range images {
+		k := tileKey{floorDiv(ci.x*16, tileSize), floorDiv(ci.z*16, tileSize)}
+		t := tiles.tile(k, bg)
+		r := image.Rect(ci.x*16, ci.z*16, ci.x*16+16, ci.z*16+16)
+		draw.Draw(t, r, ci.img, image.Point{0, 0}, draw.Src)
+		if opts.shading == nil || ci.heights == nil {
+			continue
+		}
+		h, ok := heights[k]
+		if !ok {
+			h = make([]int, tileSize*tileSize)
+			for i := range h {
+				h[i] = unsetY
+			}
+			heights[k] = h
+		}
+		for z := 0; z < 16; z++ {
+			copy(h[(r.Min.Y-t.Rect.Min.Y+z)*tileSize+r.Min.X-t.Rect.Min.X:], ci.heights[z*16:z*16+16])
+		}
+	}
+	if opts.shading != nil {
+		// looking across tile edges, so they don't show
+		height := func(x int, z int) int {
+			h, ok := heights[tileKey{floorDiv(x, tileSize), floorDiv(z, tileSize)}]
+			if !ok {
+				return unsetY
+			}
+			x, z = x-floorDiv(x, tileSize)*tileSize, z-floorDiv(z, tileSize)*tileSize
+			return h[z*tileSize+x]
+		}
+		for _, t := range tiles {
+			opts.shading.apply(t, height)
+		}
+	}
+	if info != nil && opts.dimension == mapper.Overworld {
+		for _, t := range tiles {
+			if (image.Point{info.SpawnX, info.SpawnZ}).In(t.Rect.Inset(-4)) {
+				markSpawn(t, info.SpawnX, info.SpawnZ)
+			}
+		}
+	}
+	return tiles
+}
+
+// cuts an image into tiles
+func imageTiles(img *image.RGBA) tileSet {
+	tiles := make(tileSet)
+	b := img.Bounds()
+	for ty := floorDiv(b.Min.Y, tileSize); ty*tileSize < b.Max.Y; ty++ {
+		for tx := floorDiv(b.Min.X, tileSize); tx*tileSize < b.Max.X; tx++ {
+			r := image.Rect(tx*tileSize, ty*tileSize, (tx+1)*tileSize, (ty+1)*tileSize)
+			if empty(img.SubImage(r).(*image.RGBA)) {
+				continue
+			}
+			t := tiles.tile(tileKey{tx, ty}, nil)
+			draw.Draw(t, r, img, r.Min, draw.Src)
+		}
+	}
+	return tiles
+}
+
+// true if every pixel of img is fully transparent
+func empty(img *image.RGBA) bool {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for i := 3; i < len(row); i += 4 {
+			if row[i] != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// draws tile t of one zoom level, shrunk to half its size, into its quarter
+// of out, the next zoom level out
+func (out tileSet) shrink(k tileKey, t *image.RGBA) {
+	o := out.tile(tileKey{floorDiv(k[0], 2), floorDiv(k[1], 2)}, nil)
+	min := image.Point{floorDiv(t.Rect.Min.X, 2), floorDiv(t.Rect.Min.Y, 2)}
+	for y := 0; y < tileSize/2; y++ {
+		for x := 0; x < tileSize/2; x++ {
+			o.SetRGBA(min.X+x, min.Y+y, average(t, t.Rect.Min.X+x*2, t.Rect.Min.Y+y*2))
+		}
+	}
+}
+
+// the average of the 2x2 pixels with x y at their top left.  Colours are
+// premultiplied, so transparent pixels don't darken their neighbours
+func average(img *image.RGBA, x int, y int) color.RGBA {
+	var r, g, b, a int
+	for _, p := range [4]color.RGBA{img.RGBAAt(x, y), img.RGBAAt(x+1, y), img.RGBAAt(x, y+1), img.RGBAAt(x+1, y+1)} {
+		r += int(p.R)
+		g += int(p.G)
+		b += int(p.B)
+		a += int(p.A)
+	}
+	return color.RGBA{uint8(r / 4), uint8(g / 4), uint8(b / 4), uint8(a / 4)}
+}
+
+// the zoom level which native tiles are given, so that by zoom 0 the whole
+// map is within the four tiles around 0,0
+func nativeZoom(tiles []tileKey) int {
+	extent := 1
+	for _, k := range tiles {
+		for _, v := range []int{-k[0], k[0] + 1, -k[1], k[1] + 1} {
+			if v > extent {
+				extent = v
+			}
+		}
+	}
+	zoom := 0
+	for 1<<zoom < extent {
+		zoom++
+	}
+	return zoom
+}
+
+func tilePath(dir string, zoom int, k tileKey) string {
+	return filepath.Join(dir, fmt.Sprint(zoom), fmt.Sprint(k[0]), fmt.Sprintf("%d.png", k[1]))
+}
+
+// writes the dirty tiles of native to dir/zoom/x/y.png, for Leaflet or
+// OpenLayers.  Dirty tiles which aren't in native are removed.  Returns the
+// number of tiles written
+func writeTiles(dir string, zoom int, native tileSet, dirty map[tileKey]bool, text map[string]string) (int, error) {
+	written := 0
+	for k := range dirty {
+		fn := tilePath(dir, zoom, k)
+		t, ok := native[k]
+		if !ok {
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				return written, err
+			}
+			continue
+		}
+		if err := writeTile(fn, t, text); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// writes the tiles at each zoom level out from zoom which cover the dirty
+// tiles at zoom, each made from the four tiles under it as written to dir.
+// Only one tile is held in memory at a time.  Returns the number of tiles
+// written
+func writePyramid(dir string, zoom int, dirty map[tileKey]bool, text map[string]string) (int, error) {
+	written := 0
+	for z := zoom - 1; z >= 0; z-- {
+		parents := make(map[tileKey]bool)
+		for k := range dirty {
+			parents[tileKey{floorDiv(k[0], 2), floorDiv(k[1], 2)}] = true
+		}
+		for p := range parents {
+			out := make(tileSet)
+			for _, d := range []tileKey{{0, 0}, {1, 0}, {0, 1}, {1, 1}} {
+				k := tileKey{p[0]*2 + d[0], p[1]*2 + d[1]}
+				if t := readTile(tilePath(dir, z+1, k), k); t != nil {
+					out.shrink(k, t)
+				}
+			}
+			n, err := writeTiles(dir, z, out, map[tileKey]bool{p: true}, text)
+			if err != nil {
+				return written, err
+			}
+			written += n
+		}
+		dirty = parents
+	}
+	return written, nil
+}
+
+// writes an index.html which shows the tiles in dir with Leaflet, centred on
+// centre, and with the block coordinates under the cursor if coords is true
+func writeIndex(dir string, zoom int, centre image.Point, coords bool, text map[string]string) error {
+	f, err := os.Create(filepath.Join(dir, "index.html"))
+	if err != nil {
+		return err
+	}
+	err = indexTemplate.Execute(f, map[string]interface{}{
+		"Title":   text["Title"],
+		"TileURL": "{z}/{x}/{y}.png",
+		"Zoom":    zoom,
+		"MinZoom": 0,
+		"CentreX": centre.X,
+		"CentreZ": centre.Y,
+		"Coords":  coords,
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// reads a tile written by an earlier run, or returns nil if there isn't one
+func readTile(fn string, k tileKey) *image.RGBA {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		log.Printf("%s: %s", fn, err)
+		return nil
+	}
+	t := make(tileSet).tile(k, nil)
+	draw.Draw(t, t.Rect, img, img.Bounds().Min, draw.Src)
+	return t
+}
+
+// writes a tile by way of a temporary file, so that it can be served while
+// being replaced
+func writeTile(fn string, tile *image.RGBA, text map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fn), ".tile*")
+	if err != nil {
+		return err
+	}
+	if err := encodePNG(f, tile, text); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), fn)
+}
+
+// a Leaflet viewer for the tiles.  At the native zoom a pixel is a block, so
+// block x z is at lat -z/2^zoom, lng x/2^zoom.  Tiles further out than
+// MinZoom aren't asked for
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{with .Title}}{{.}}{{else}}mapper{{end}}</title>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+<style>
+html, body, #map { height: 100%; margin: 0; background: #000; }
+.coords { background: rgba(255, 255, 255, 0.8); padding: 2px 6px; font: 12px monospace; }
+</style>
+</head>
+<body>
+<div id="map"></div>
+<script>
+var zoom = {{.Zoom}};
+var scale = Math.pow(2, zoom);
+var map = L.map('map', {crs: L.CRS.Simple, minZoom: {{.MinZoom}}, maxZoom: zoom + 3});
+L.tileLayer({{.TileURL}}, {
+  tileSize: 256,
+  minZoom: {{.MinZoom}},
+  maxNativeZoom: zoom,
+  maxZoom: zoom + 3,
+  noWrap: true
+}).addTo(map);
+map.setView([-{{.CentreZ}} / scale, {{.CentreX}} / scale], zoom);
+{{- if .Coords}}
+var coords = L.control({position: 'bottomleft'});
+coords.onAdd = function() {
+  return L.DomUtil.create('div', 'coords');
+};
+coords.addTo(map);
+map.on('mousemove', function(e) {
+  var x = Math.floor(e.latlng.lng * scale), z = Math.floor(-e.latlng.lat * scale);
+  coords.getContainer().textContent = 'x ' + x + ', z ' + z;
+});
+map.on('mouseout', function() {
+  coords.getContainer().textContent = '';
+});
+{{- end}}
+</script>
+</body>
+</html>
+`))
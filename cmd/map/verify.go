@@ -0,0 +1,62 @@
+package main
+
+import "flag"
+import "fmt"
+import "log"
+import "os"
+
+import "github.com/timocp/mapper"
+
+// map verify [-repair] regionfile...
+//
+// reports problems with each chunk of each region file, and optionally
+// rewrites the files without them
+func verifyMain(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	optRepair := flags.Bool("repair", false, "drop unreadable chunks and relocate misplaced ones")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s verify [-repair] regionfile...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	bad := 0
+	for _, fn := range flags.Args() {
+		var problems []mapper.Problem
+		summary := ""
+		if *optRepair {
+			result, err := mapper.Repair(fn)
+			if err != nil {
+				log.Printf("%s: %s", fn, err)
+				bad++
+				continue
+			}
+			problems = result.Problems
+			summary = fmt.Sprintf("%s: repaired, dropped %d chunks, relocated %d\n", fn, result.Dropped, result.Relocated)
+		} else {
+			r := new(mapper.Region)
+			if err := r.Open(fn); err != nil {
+				log.Printf("%s: %s", fn, err)
+				bad++
+				continue
+			}
+			var err error
+			problems, err = r.Verify()
+			r.Close()
+			if err != nil {
+				log.Printf("%s: %s", fn, err)
+				bad++
+				continue
+			}
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", fn, p)
+		}
+		if len(problems) > 0 {
+			fmt.Print(summary)
+			bad++
+		}
+	}
+	if bad > 0 && !*optRepair {
+		os.Exit(1)
+	}
+}
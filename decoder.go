@@ -0,0 +1,392 @@
+package mapper
+
+import "fmt"
+import "sort"
+
+import "github.com/timocp/nbt"
+
+// DataVersions at which the chunk format changed
+const (
+	// 17w47a (1.13): numeric block ids replaced by a palette of names
+	VersionFlattening = 1451
+	// 20w17a (1.16): palette indexes no longer span two longs
+	VersionPacking = 2529
+	// 21w43a (1.18): "Level" removed, world extends below y=0
+	VersionCubic = 2844
+)
+
+// A Decoder understands the layout of chunks written by a range of
+// Minecraft versions.  Missing or mistyped tags are reported with a
+// MissingTagError or TagTypeError
+type Decoder interface {
+	// returns the compound holding the chunk's sections, heightmaps etc
+	Level(root nbt.CompoundTag) (nbt.CompoundTag, error)
+	// returns the sections of the chunk which have any blocks in them
+	Sections(level nbt.CompoundTag) ([]nbt.CompoundTag, error)
+	// decodes the 4096 blocks of a section returned by Sections
+	Blocks(section nbt.CompoundTag) (*SectionBlocks, error)
+	// returns the height of each of the 256 columns, in ZX order
+	HeightMap(level nbt.CompoundTag) ([]int32, error)
+}
+
+// the decoded blocks of a 16x16x16 section
+type SectionBlocks struct {
+	Palette []Block
+	Index   [4096]uint16 // into Palette, in YZX order
+}
+
+// returns the block at x y z relative to the section
+func (s *SectionBlocks) At(x int, y int, z int) Block {
+	return s.Palette[s.Index[y*16*16+z*16+x]]
+}
+
+type versionDecoder struct {
+	minVersion int
+	decoder    Decoder
+}
+
+// sorted by minVersion
+var decoders = []versionDecoder{
+	{0, legacyDecoder{}},
+	{VersionFlattening, flatteningDecoder{spanning: true}},
+	{VersionPacking, flatteningDecoder{spanning: false}},
+	{VersionCubic, cubicDecoder{}},
+}
+
+// use d for chunks with a DataVersion of at least minVersion (until the next
+// registered version).  Replaces any decoder already registered for
+// minVersion.  Not safe to call while chunks are being read; do it at init
+// time
+func RegisterDecoder(minVersion int, d Decoder) {
+	for i := range decoders {
+		if decoders[i].minVersion == minVersion {
+			decoders[i].decoder = d
+			return
+		}
+	}
+	decoders = append(decoders, versionDecoder{minVersion, d})
+	sort.Slice(decoders, func(i, j int) bool {
+		return decoders[i].minVersion < decoders[j].minVersion
+	})
+}
+
+// returns the decoder for chunks written with the given DataVersion
+func decoderFor(version int) Decoder {
+	d := decoders[0].decoder
+	for _, vd := range decoders {
+		if vd.minVersion > version {
+			break
+		}
+		d = vd.decoder
+	}
+	return d
+}
+
+// before 1.13: numeric ids in "Blocks", "Add" and "Data" byte arrays
+type legacyDecoder struct{}
+
+func (legacyDecoder) Level(root nbt.CompoundTag) (nbt.CompoundTag, error) {
+	return compoundChild(root, "Level")
+}
+
+func (legacyDecoder) Sections(level nbt.CompoundTag) ([]nbt.CompoundTag, error) {
+	return sectionsWith(level, "Sections", "Blocks")
+}
+
+func (legacyDecoder) Blocks(section nbt.CompoundTag) (*SectionBlocks, error) {
+	blocks, err := byteArrayChild(section, "Blocks")
+	if err != nil {
+		return nil, err
+	}
+	data, err := byteArrayChild(section, "Data")
+	if err != nil {
+		return nil, err
+	}
+	var add []byte
+	if section.ChildByName("Add") != nil {
+		if add, err = byteArrayChild(section, "Add"); err != nil {
+			return nil, err
+		}
+	}
+	if len(blocks) != 4096 || len(data) != 2048 || (add != nil && len(add) != 2048) {
+		return nil, fmt.Errorf("section arrays have wrong lengths %d/%d/%d", len(blocks), len(data), len(add))
+	}
+	s := new(SectionBlocks)
+	// build a palette of the distinct id/data pairs
+	seen := make(map[[2]int16]uint16)
+	for blockPos := range s.Index {
+		idB := byte(0)
+		if add != nil {
+			idB = nibble4(add, blockPos)
+		}
+		b := NewBlock(int16(blocks[blockPos])+int16(idB)<<8, int8(nibble4(data, blockPos)))
+		key := [2]int16{b.Id, int16(b.Data)}
+		i, ok := seen[key]
+		if !ok {
+			i = uint16(len(s.Palette))
+			seen[key] = i
+			s.Palette = append(s.Palette, b)
+		}
+		s.Index[blockPos] = i
+	}
+	return s, nil
+}
+
+func (legacyDecoder) HeightMap(level nbt.CompoundTag) ([]int32, error) {
+	return intArrayChild(level, "HeightMap")
+}
+
+// 1.13 to 1.17: a "Palette" of named blocks per section, indexed by the
+// packed "BlockStates" long array.  Before 1.16 the indexes may span longs
+type flatteningDecoder struct {
+	spanning bool
+}
+
+func (flatteningDecoder) Level(root nbt.CompoundTag) (nbt.CompoundTag, error) {
+	return compoundChild(root, "Level")
+}
+
+func (flatteningDecoder) Sections(level nbt.CompoundTag) ([]nbt.CompoundTag, error) {
+	// since 1.14 there are also sections which only hold light data
+	return sectionsWith(level, "Sections", "BlockStates")
+}
+
+func (d flatteningDecoder) Blocks(section nbt.CompoundTag) (*SectionBlocks, error) {
+	palette, err := listChild(section, "Palette")
+	if err != nil {
+		return nil, err
+	}
+	states, err := longArrayChild(section, "BlockStates")
+	if err != nil {
+		return nil, err
+	}
+	return paletteBlocks(palette, states, d.spanning)
+}
+
+func (d flatteningDecoder) HeightMap(level nbt.CompoundTag) ([]int32, error) {
+	return surfaceHeights(level, d.spanning, 0)
+}
+
+// 1.18+: no "Level" wrapper, and each section has a "block_states" compound
+// holding its palette and indexes.  Every section is stored even if it's all
+// air, and the world starts at section "yPos" (-4 in the overworld)
+type cubicDecoder struct{}
+
+func (cubicDecoder) Level(root nbt.CompoundTag) (nbt.CompoundTag, error) {
+	return root, nil
+}
+
+func (cubicDecoder) Sections(level nbt.CompoundTag) ([]nbt.CompoundTag, error) {
+	sections, err := sectionsWith(level, "sections", "block_states")
+	if err != nil {
+		return nil, err
+	}
+	var result []nbt.CompoundTag
+	for _, s := range sections {
+		states, err := compoundChild(s, "block_states")
+		if err != nil {
+			return nil, err
+		}
+		palette, err := listChild(states, "palette")
+		if err != nil {
+			return nil, err
+		}
+		if len(palette.Values) == 1 {
+			if entry, ok := palette.Values[0].(nbt.CompoundTag); ok {
+				if name, _ := stringChild(entry, "Name"); name == "minecraft:air" {
+					continue
+				}
+			}
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+func (cubicDecoder) Blocks(section nbt.CompoundTag) (*SectionBlocks, error) {
+	states, err := compoundChild(section, "block_states")
+	if err != nil {
+		return nil, err
+	}
+	palette, err := listChild(states, "palette")
+	if err != nil {
+		return nil, err
+	}
+	var data []int64
+	if states.ChildByName("data") != nil {
+		if data, err = longArrayChild(states, "data"); err != nil {
+			return nil, err
+		}
+	}
+	return paletteBlocks(palette, data, false)
+}
+
+func (cubicDecoder) HeightMap(level nbt.CompoundTag) ([]int32, error) {
+	bottom := 0
+	if level.ChildByName("yPos") != nil {
+		yPos, err := intChild(level, "yPos")
+		if err != nil {
+			return nil, err
+		}
+		bottom = yPos * 16
+	}
+	return surfaceHeights(level, false, bottom)
+}
+
+// returns the sections from the named list which have the named child.  A
+// chunk with no sections at all has no list
+func sectionsWith(level nbt.CompoundTag, list string, child string) ([]nbt.CompoundTag, error) {
+	if level.ChildByName(list) == nil {
+		return nil, nil
+	}
+	sections, err := listChild(level, list)
+	if err != nil {
+		return nil, err
+	}
+	var result []nbt.CompoundTag
+	for _, t := range sections.Values {
+		s, ok := t.(nbt.CompoundTag)
+		if !ok {
+			return nil, tagTypeError(list, s, t)
+		}
+		if _, err := byteChild(s, "Y"); err != nil {
+			return nil, err
+		}
+		if s.ChildByName(child) != nil {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
+
+// decodes a palette list and its packed long array of indexes.  states may be
+// nil (1.18+) when the palette only has one entry
+func paletteBlocks(palette nbt.ListTag, states []int64, spanning bool) (*SectionBlocks, error) {
+	s := new(SectionBlocks)
+	for _, t := range palette.Values {
+		entry, ok := t.(nbt.CompoundTag)
+		if !ok {
+			return nil, tagTypeError("palette", entry, t)
+		}
+		b, err := paletteBlock(entry)
+		if err != nil {
+			return nil, err
+		}
+		s.Palette = append(s.Palette, b)
+	}
+	if len(s.Palette) == 0 {
+		return nil, fmt.Errorf("empty palette")
+	}
+	if states == nil {
+		return s, nil
+	}
+	bits := paletteBits(len(s.Palette), 4)
+	if len(states) < packedLen(bits, 4096, spanning) {
+		return nil, fmt.Errorf("%d block states for %d bit indexes", len(states), bits)
+	}
+	for i := range s.Index {
+		s.Index[i] = uint16(unpack(states, bits, spanning, i))
+		if int(s.Index[i]) >= len(s.Palette) {
+			return nil, fmt.Errorf("block state %d out of range of palette of %d", s.Index[i], len(s.Palette))
+		}
+	}
+	return s, nil
+}
+
+// converts a palette entry such as
+// {Name: "minecraft:oak_log", Properties: {axis: "y"}} to a Block
+func paletteBlock(entry nbt.CompoundTag) (Block, error) {
+	name, err := stringChild(entry, "Name")
+	if err != nil {
+		return airBlock, err
+	}
+	var properties map[string]string
+	if entry.ChildByName("Properties") != nil {
+		p, err := compoundChild(entry, "Properties")
+		if err != nil {
+			return airBlock, err
+		}
+		properties = make(map[string]string)
+		for _, t := range p.Values {
+			s, ok := t.(nbt.StringTag)
+			if !ok {
+				return airBlock, tagTypeError("Properties", s, t)
+			}
+			properties[s.Name] = s.Value
+		}
+	}
+	return NewNamedBlock(name, properties), nil
+}
+
+// decodes the WORLD_SURFACE heightmap, 9 bit values relative to bottom
+func surfaceHeights(level nbt.CompoundTag, spanning bool, bottom int) ([]int32, error) {
+	heightmaps, err := compoundChild(level, "Heightmaps")
+	if err != nil {
+		return nil, err
+	}
+	packed, err := longArrayChild(heightmaps, "WORLD_SURFACE")
+	if err != nil {
+		return nil, err
+	}
+	if len(packed) < packedLen(9, 256, spanning) {
+		return nil, fmt.Errorf("heightmap too short (%d)", len(packed))
+	}
+	heights := make([]int32, 256)
+	for i := range heights {
+		heights[i] = int32(unpack(packed, 9, spanning, i) + bottom)
+	}
+	return heights, nil
+}
+
+// the number of bits needed to store an index into a palette of length n,
+// but at least min
+func paletteBits(n int, min int) int {
+	bits := min
+	for 1<<uint(bits) < n {
+		bits++
+	}
+	return bits
+}
+
+// the number of longs needed to pack count values of the given bit size
+func packedLen(bits int, count int, spanning bool) int {
+	if spanning {
+		return (count*bits + 63) / 64
+	}
+	perLong := 64 / bits
+	return (count + perLong - 1) / perLong
+}
+
+// from a long array of packed values of the given bit size, extract the item
+// at the specified index.
+//
+// Before 1.16 the values are packed end to end and may span two longs.
+// Since 1.16 each long holds as many whole values as fit, with the left over
+// high bits unused.
+func unpack(values []int64, bits int, spanning bool, index int) int {
+	mask := uint64(1)<<uint(bits) - 1
+	if spanning {
+		bit := index * bits
+		i, offset := bit/64, uint(bit%64)
+		v := uint64(values[i]) >> offset
+		if offset+uint(bits) > 64 {
+			v |= uint64(values[i+1]) << (64 - offset)
+		}
+		return int(v & mask)
+	}
+	perLong := 64 / bits
+	offset := uint(index%perLong) * uint(bits)
+	return int(uint64(values[index/perLong]) >> offset & mask)
+}
+
+// from examples on http://minecraft.gamepedia.com/Chunk_format#Block_format
+//
+// from a byte array where each byte is a 4 bit number, extract the item at
+// the specified index
+func nibble4(arr []byte, index int) byte {
+	if index%2 == 0 {
+		return arr[index/2] & 0x0F
+	} else {
+		return (arr[index/2] >> 4) & 0x0F
+	}
+}
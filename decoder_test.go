@@ -0,0 +1,167 @@
+package mapper
+
+import "bytes"
+import "encoding/binary"
+import "fmt"
+import "testing"
+
+import "github.com/timocp/nbt"
+
+// packs values of the given bit size the way unpack reads them
+func testPack(values []int, bits int, spanning bool) []int64 {
+	packed := make([]uint64, packedLen(bits, len(values), spanning))
+	for i, v := range values {
+		if spanning {
+			bit := i * bits
+			packed[bit/64] |= uint64(v) << uint(bit%64)
+			if bit%64+bits > 64 {
+				packed[bit/64+1] |= uint64(v) >> uint(64-bit%64)
+			}
+		} else {
+			perLong := 64 / bits
+			packed[i/perLong] |= uint64(v) << uint(i%perLong*bits)
+		}
+	}
+	result := make([]int64, len(packed))
+	for i, v := range packed {
+		result[i] = int64(v)
+	}
+	return result
+}
+
+func TestPackedLen(t *testing.T) {
+	for _, test := range []struct {
+		bits     int
+		spanning bool
+		want     int
+	}{
+		{4, true, 256}, {4, false, 256},
+		{5, true, 320}, {5, false, 342}, // 12 to a long, 4 bits left over
+		{9, true, 576}, {9, false, 586}, // 7 to a long
+		{12, true, 768}, {12, false, 820}, // 5 to a long
+	} {
+		if got := packedLen(test.bits, 4096, test.spanning); got != test.want {
+			t.Errorf("packedLen(%d, 4096, %t) = %d, want %d", test.bits, test.spanning, got, test.want)
+		}
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	// by hand: a 5 bit 22 (10110) at index 12 spans the top 4 bits of the
+	// first long and the bottom bit of the second before 1.16, and starts
+	// the second long since, with the first's top 4 bits unused
+	for _, test := range []struct {
+		packed   []int64
+		spanning bool
+		index    int
+		want     int
+	}{
+		{[]int64{6 << 60, 1}, true, 12, 22},
+		{[]int64{6 << 60, 1}, true, 11, 0},
+		{[]int64{-1 << 60, 22}, false, 12, 22},
+		{[]int64{-1 << 60, 22}, false, 11, 0},
+	} {
+		if got := unpack(test.packed, 5, test.spanning, test.index); got != test.want {
+			t.Errorf("unpack(%x, 5, %t, %d) = %d, want %d", test.packed, test.spanning, test.index, got, test.want)
+		}
+	}
+	// a section's worth of values at each size where values start to span
+	// longs, or leave bits over
+	for _, bits := range []int{4, 5, 9, 12} {
+		for _, spanning := range []bool{true, false} {
+			values := make([]int, 4096)
+			for i := range values {
+				values[i] = (i*7919 + i/3) & (1<<uint(bits) - 1)
+			}
+			values[4095] = 1<<uint(bits) - 1
+			packed := testPack(values, bits, spanning)
+			for i, want := range values {
+				if got := unpack(packed, bits, spanning, i); got != want {
+					t.Errorf("%d bits, spanning %t: index %d is %d, want %d", bits, spanning, i, got, want)
+					break
+				}
+			}
+		}
+	}
+}
+
+// a palette list of blocks with the given names, as in a section
+func testPalette(t *testing.T, names []string) nbt.ListTag {
+	var b bytes.Buffer
+	name := func(typ byte, s string) {
+		b.WriteByte(typ)
+		binary.Write(&b, binary.BigEndian, uint16(len(s)))
+		b.WriteString(s)
+	}
+	name(10, "")
+	name(9, "palette")
+	b.WriteByte(10)
+	binary.Write(&b, binary.BigEndian, int32(len(names)))
+	for _, n := range names {
+		name(8, "Name")
+		binary.Write(&b, binary.BigEndian, uint16(len(n)))
+		b.WriteString(n)
+		b.WriteByte(0)
+	}
+	b.WriteByte(0)
+	palette, err := listChild(nbt.Parse(&b).(nbt.CompoundTag), "palette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return palette
+}
+
+// palettes of 16 and 17 blocks take 4 and 5 bits, and of 256 and 257 take 8
+// and 9
+func TestPaletteBlocks(t *testing.T) {
+	for _, size := range []int{16, 17, 256, 257} {
+		for _, spanning := range []bool{true, false} {
+			names := make([]string, size)
+			for i := range names {
+				names[i] = fmt.Sprintf("test:block_%d", i)
+			}
+			values := make([]int, 4096)
+			for i := range values {
+				values[i] = (i * 31) % size
+			}
+			bits := paletteBits(size, 4)
+			s, err := paletteBlocks(testPalette(t, names), testPack(values, bits, spanning), spanning)
+			if err != nil {
+				t.Fatalf("%d blocks, spanning %t: %s", size, spanning, err)
+			}
+			for i, want := range values {
+				if got := s.At(i&15, i>>8, i>>4&15).Name(); got != names[want] {
+					t.Errorf("%d blocks, spanning %t: block %d is %s, want %s", size, spanning, i, got, names[want])
+					break
+				}
+			}
+			// one long short
+			if _, err := paletteBlocks(testPalette(t, names), testPack(values, bits, spanning)[1:], spanning); err == nil {
+				t.Errorf("%d blocks, spanning %t: no error for short block states", size, spanning)
+			}
+		}
+	}
+}
+
+// Blocks can be compared and used as map keys, properties and all
+func TestBlockComparable(t *testing.T) {
+	a := NewNamedBlock("minecraft:oak_log", map[string]string{"axis": "y", "a": "b"})
+	b := NewNamedBlock("minecraft:oak_log", map[string]string{"a": "b", "axis": "y"})
+	c := NewNamedBlock("minecraft:oak_log", map[string]string{"axis": "x", "a": "b"})
+	if a != b || a == c {
+		t.Errorf("%v == %v is %t, %v == %v is %t", a, b, a == b, a, c, a == c)
+	}
+	seen := map[Block]bool{a: true}
+	if !seen[b] || seen[c] {
+		t.Error("Blocks as map keys don't match by properties")
+	}
+	if v, ok := c.Property("axis"); !ok || v != "x" {
+		t.Errorf("axis is %q, %t", v, ok)
+	}
+	if _, ok := c.Property("ax"); ok {
+		t.Error("found property ax")
+	}
+	if props := a.Properties(); len(props) != 2 || props["axis"] != "y" || props["a"] != "b" {
+		t.Errorf("properties %v", props)
+	}
+}
@@ -0,0 +1,41 @@
+package mapper
+
+import "fmt"
+
+// a tag required by the chunk format is not present
+type MissingTagError struct {
+	Name string
+}
+
+func (e *MissingTagError) Error() string {
+	return fmt.Sprintf("missing tag %q", e.Name)
+}
+
+// a tag is present but isn't of the type the chunk format requires
+type TagTypeError struct {
+	Name string
+	Want string // eg "nbt.CompoundTag"
+	Got  string
+}
+
+func (e *TagTypeError) Error() string {
+	return fmt.Sprintf("tag %q is %s, want %s", e.Name, e.Got, e.Want)
+}
+
+// a legacy numeric block id which this package doesn't know the name of
+type UnknownBlockError struct {
+	Id int16
+}
+
+func (e *UnknownBlockError) Error() string {
+	return fmt.Sprintf("unknown block id %d", e.Id)
+}
+
+// the NBT data of a chunk couldn't be parsed
+type ParseError struct {
+	Reason interface{} // whatever the nbt package panicked with
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("nbt parse: %v", e.Reason)
+}
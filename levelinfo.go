@@ -0,0 +1,100 @@
+package mapper
+
+import "time"
+
+import "github.com/timocp/nbt"
+
+// the interesting parts of level.dat
+type LevelInfo struct {
+	Name        string
+	Seed        int64
+	SpawnX      int
+	SpawnY      int
+	SpawnZ      int
+	Version     string // eg "1.20.4"; "" if written before 1.9
+	DataVersion int
+	Time        int64 // ticks since the world was created
+	DayTime     int64 // ticks; the time of day is DayTime % 24000
+	LastPlayed  time.Time
+	GameType    int // 0 survival, 1 creative, 2 adventure, 3 spectator
+	Hardcore    bool
+	GameRules   map[string]string
+}
+
+// reads a level.dat file
+func ReadLevelInfo(fn string) (*LevelInfo, error) {
+	root, err := readLevelDat(fn)
+	if err != nil {
+		return nil, err
+	}
+	data, err := compoundChild(root, "Data")
+	if err != nil {
+		return nil, err
+	}
+	return NewLevelInfo(data)
+}
+
+// extracts a LevelInfo from the "Data" compound of level.dat.  Missing tags
+// are left as zero values, as older versions don't have all of them
+func NewLevelInfo(data nbt.CompoundTag) (*LevelInfo, error) {
+	info := &LevelInfo{GameRules: make(map[string]string)}
+	// moved into compounds by later versions
+	settings, err := optionalCompound(data, "WorldGenSettings") // 1.16
+	if err != nil {
+		return nil, err
+	}
+	version, err := optionalCompound(data, "Version") // 1.9
+	if err != nil {
+		return nil, err
+	}
+	spawn, err := optionalCompound(data, "spawn") // 1.21.9
+	if err != nil {
+		return nil, err
+	}
+	var lastPlayed int64
+	var hardcore int
+	for _, err := range []error{
+		optionalString(data, "LevelName", &info.Name),
+		optionalLong(data, "RandomSeed", &info.Seed),
+		optionalLong(settings, "seed", &info.Seed),
+		optionalInt(data, "SpawnX", &info.SpawnX),
+		optionalInt(data, "SpawnY", &info.SpawnY),
+		optionalInt(data, "SpawnZ", &info.SpawnZ),
+		optionalString(version, "Name", &info.Version),
+		optionalInt(data, "DataVersion", &info.DataVersion),
+		optionalLong(data, "Time", &info.Time),
+		optionalLong(data, "DayTime", &info.DayTime),
+		optionalLong(data, "LastPlayed", &lastPlayed),
+		optionalInt(data, "GameType", &info.GameType),
+	} {
+		if err != nil {
+			return nil, err
+		}
+	}
+	if data.ChildByName("hardcore") != nil {
+		if hardcore, err = byteChild(data, "hardcore"); err != nil {
+			return nil, err
+		}
+	}
+	info.Hardcore = hardcore != 0
+	if spawn.ChildByName("pos") != nil {
+		pos, err := intArrayChild(spawn, "pos")
+		if err != nil {
+			return nil, err
+		}
+		if len(pos) == 3 {
+			info.SpawnX, info.SpawnY, info.SpawnZ = int(pos[0]), int(pos[1]), int(pos[2])
+		}
+	}
+	info.LastPlayed = time.Unix(lastPlayed/1000, lastPlayed%1000*int64(time.Millisecond))
+	rules, err := optionalCompound(data, "GameRules")
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range rules.Values {
+		if s, ok := t.(nbt.StringTag); ok {
+			info.GameRules[s.Name] = s.Value
+		}
+	}
+	return info, nil
+}
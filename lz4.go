@@ -0,0 +1,138 @@
+package mapper
+
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+
+// Minecraft's LZ4 chunk compression is the block stream format written by
+// lz4-java's LZ4BlockOutputStream, not the standard LZ4 frame format.  Each
+// block has a 21 byte header:
+//
+//	magic "LZ4Block"
+//	token: high nibble is the method (0x10 stored, 0x20 lz4), low nibble the level
+//	compressed length (little-endian uint32)
+//	decompressed length (little-endian uint32)
+//	xxhash32 checksum of the decompressed data (not checked here)
+//
+// and the stream ends with a block whose decompressed length is 0.
+var lz4BlockMagic = []byte("LZ4Block")
+
+var lz4CorruptError = errors.New("lz4: corrupt input")
+
+func decompressLZ4Stream(data []byte) ([]byte, error) {
+	var out []byte
+	for len(data) > 0 {
+		if len(data) < 21 || !bytes.Equal(data[:8], lz4BlockMagic) {
+			return nil, fmt.Errorf("lz4: bad block header")
+		}
+		method := data[8] & 0xF0
+		compressedLen := int(binary.LittleEndian.Uint32(data[9:13]))
+		decompressedLen := int(binary.LittleEndian.Uint32(data[13:17]))
+		data = data[21:]
+		if decompressedLen == 0 {
+			// end of stream marker
+			break
+		}
+		if compressedLen < 0 || compressedLen > len(data) {
+			return nil, fmt.Errorf("lz4: block length %d exceeds remaining %d bytes", compressedLen, len(data))
+		}
+		switch method {
+		case 0x10:
+			if decompressedLen != compressedLen {
+				return nil, fmt.Errorf("lz4: stored block of %d bytes claims %d", compressedLen, decompressedLen)
+			}
+			out = append(out, data[:compressedLen]...)
+		case 0x20:
+			block, err := decompressLZ4Block(data[:compressedLen], decompressedLen)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, block...)
+		default:
+			return nil, fmt.Errorf("lz4: unknown block method %#x", method)
+		}
+		data = data[compressedLen:]
+	}
+	return out, nil
+}
+
+// the most a block can expand by: a match is at least one token byte per 255
+// bytes of output.  The small allowance is for blocks of only a few bytes
+func lz4MaxDecompressed(compressedLen int) int {
+	return compressedLen*255 + 16
+}
+
+// decompresses a single raw LZ4 block, which should expand to size bytes.
+// See https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md
+func decompressLZ4Block(src []byte, size int) ([]byte, error) {
+	// checked before allocating, so a corrupt header can't ask for gigabytes
+	if size < 0 || size > lz4MaxDecompressed(len(src)) {
+		return nil, fmt.Errorf("lz4: block of %d bytes can't decompress to %d", len(src), size)
+	}
+	dst := make([]byte, 0, size)
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		// literals
+		n, i2, err := lz4Length(src, i, int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		i = i2
+		if i+n > len(src) {
+			return nil, lz4CorruptError
+		}
+		dst = append(dst, src[i:i+n]...)
+		i += n
+		if i == len(src) {
+			// the last sequence has no match
+			break
+		}
+		// match
+		if i+2 > len(src) {
+			return nil, lz4CorruptError
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, lz4CorruptError
+		}
+		n, i, err = lz4Length(src, i, int(token&0x0F))
+		if err != nil {
+			return nil, err
+		}
+		n += 4
+		if len(dst)+n > size {
+			return nil, lz4CorruptError
+		}
+		// byte by byte as the match may overlap what it's copying
+		start := len(dst) - offset
+		for j := 0; j < n; j++ {
+			dst = append(dst, dst[start+j])
+		}
+	}
+	if len(dst) != size {
+		return nil, fmt.Errorf("lz4: block decompressed to %d bytes, want %d", len(dst), size)
+	}
+	return dst, nil
+}
+
+// reads the extended part of a literal or match length which starts in the
+// nibble n of a token, returning the length and the new position in src
+func lz4Length(src []byte, i int, n int) (int, int, error) {
+	if n != 15 {
+		return n, i, nil
+	}
+	for {
+		if i >= len(src) {
+			return 0, i, lz4CorruptError
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package mapper
+
+import "bytes"
+import "encoding/binary"
+import "testing"
+
+// an LZ4BlockOutputStream block header followed by its data
+func lz4TestBlock(method byte, decompressedLen int, data []byte) []byte {
+	b := append([]byte("LZ4Block"), method|0x06)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(data)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(decompressedLen))
+	b = append(b, 0, 0, 0, 0) // checksum, which isn't checked
+	return append(b, data...)
+}
+
+func TestDecompressLZ4Stream(t *testing.T) {
+	compressed := []byte{
+		// 3 literals, then a match 3 back of 4+5 bytes, overlapping itself
+		0x35, 'a', 'b', 'c', 0x03, 0x00,
+		// 20 literals (15 + 5 in an extra byte) and no match to end the block
+		0xF0, 5, 'z', 'z', 'z', 'z', 'z', 'z', 'z', 'z', 'z', 'z',
+		'z', 'z', 'z', 'z', 'z', 'z', 'z', 'z', 'z', 'z',
+	}
+	want := "abcabcabcabc" + "zzzzzzzzzzzzzzzzzzzz"
+	var stream []byte
+	stream = append(stream, lz4TestBlock(0x20, len(want), compressed)...)
+	stream = append(stream, lz4TestBlock(0x10, 5, []byte("hello"))...)
+	stream = append(stream, lz4TestBlock(0x10, 0, nil)...)
+	got, err := decompressLZ4Stream(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want+"hello" {
+		t.Errorf("got %q, want %q", got, want+"hello")
+	}
+}
+
+func TestDecompressLZ4Corrupt(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		stream []byte
+	}{
+		{"bad magic", append([]byte("LZ4Blocc"), make([]byte, 13)...)},
+		{"short header", []byte("LZ4Block")},
+		{"huge length", lz4TestBlock(0x20, 1<<31, []byte{0x10, 'a'})},
+		{"stored length mismatch", lz4TestBlock(0x10, 6, []byte("hello"))},
+		{"block past end", lz4TestBlock(0x20, 5, []byte{0x50, 'a'})[:25]},
+		{"offset before start", lz4TestBlock(0x20, 9, []byte{0x10, 'a', 0x05, 0x00, 0x10, 'b'})},
+		{"match past size", lz4TestBlock(0x20, 5, []byte{0x1F, 'a', 0x01, 0x00, 200, 0x00})},
+		{"unknown method", lz4TestBlock(0x30, 1, []byte{0})},
+	} {
+		if got, err := decompressLZ4Stream(test.stream); err == nil {
+			t.Errorf("%s: got %q, want an error", test.name, got)
+		}
+	}
+}
+
+func TestDecompressLZ4Empty(t *testing.T) {
+	got, err := decompressLZ4Stream(lz4TestBlock(0x10, 0, nil))
+	if err != nil || !bytes.Equal(got, nil) {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,633 @@
+package mapper
+
+import "archive/zip"
+import "encoding/json"
+import "fmt"
+import "image"
+import "image/color"
+import _ "image/png"
+import "io/fs"
+import "os"
+import "path"
+import "sort"
+import "strconv"
+import "strings"
+import "sync"
+
+// A Palette maps namespaced block names to the colours they're drawn with,
+// for blocks which Block.Colour doesn't know or gets wrong, and biomes to the
+// colours of biome maps.  A nil Palette has no colours of its own
+type Palette struct {
+	Blocks map[string]color.RGBA
+	// colours for blocks with particular properties, which take precedence
+	// over Blocks.  Legacy blocks have a "data" property, their data value
+	States map[string][]BlockState
+	// by namespaced name, or legacy numeric id (as a string)
+	Biomes map[string]color.RGBA
+	// what the palette was made from, for programs which keep it to tell
+	// whether it needs making again.  Kept by Save and ReadPalette
+	Source string
+}
+
+// a colour for the blocks of one name whose properties include these
+type BlockState struct {
+	Properties map[string]string
+	Colour     color.RGBA
+}
+
+func NewPalette() *Palette {
+	return &Palette{
+		Blocks: make(map[string]color.RGBA),
+		States: make(map[string][]BlockState),
+		Biomes: make(map[string]color.RGBA),
+	}
+}
+
+// returns the palette's colour for b, or b.Colour() if it hasn't one.  If
+// several states match, the one with the most properties is used
+func (p *Palette) Colour(b Block) color.RGBA {
+	if c, ok := p.lookup(b); ok {
+		return c
+	}
+	return b.Colour()
+}
+
+// returns the palette's colour for b, and false if it hasn't one
+func (p *Palette) lookup(b Block) (color.RGBA, bool) {
+	if p == nil {
+		return color.RGBA{}, false
+	}
+	props := func(k string) string {
+		return blockProperty(b, k)
+	}
+	for _, name := range paletteNames(b) {
+		best := -1
+		var c color.RGBA
+		for _, state := range p.States[name] {
+			if len(state.Properties) > best && state.matches(props) {
+				best, c = len(state.Properties), state.Colour
+			}
+		}
+		if best >= 0 {
+			return c, true
+		}
+		if c, ok := p.Blocks[name]; ok {
+			return c, true
+		}
+	}
+	return color.RGBA{}, false
+}
+
+// props returns the value of a property of the block, or "" if it hasn't it
+func (s BlockState) matches(props func(k string) string) bool {
+	for k, v := range s.Properties {
+		if props(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// the 16 dye colours, in the order of legacy data values
+var dyeColours = [16]string{
+	"white", "orange", "magenta", "light_blue", "yellow", "lime", "pink", "gray",
+	"light_gray", "cyan", "purple", "blue", "brown", "green", "red", "black",
+}
+
+// legacy blocks whose data value is their colour, and the ends of the names
+// the flattening gave each colour of them, eg "minecraft:red_wool"
+var colouredBlocks = map[int16]string{
+	Wool:                  "_wool",
+	Stained_glass:         "_stained_glass",
+	Stained_hardened_clay: "_terracotta",
+	Stained_glass_pane:    "_stained_glass_pane",
+	Carpet:                "_carpet",
+}
+
+// the value of a property palette states are matched against, or "" if b
+// hasn't it.  Legacy blocks have "data", their data value.  Blocks which
+// come in the 16 colours also have "color", whether it's in their data or
+// their name, so that "wool[color=red]" matches red wool from before and
+// after the flattening
+func blockProperty(b Block, k string) string {
+	if b.name == "" {
+		switch k {
+		case "data":
+			return strconv.Itoa(int(b.Data))
+		case "color":
+			if _, ok := colouredBlocks[b.Id]; ok {
+				return dyeColours[b.Data&15]
+			}
+		}
+		return ""
+	}
+	if v, ok := b.Property(k); ok {
+		return v
+	}
+	if k == "color" {
+		if _, colour, ok := flattenedColour(b.name); ok {
+			return colour
+		}
+	}
+	return ""
+}
+
+// for one of the flattened names of a coloured legacy block, eg
+// "minecraft:red_wool", returns the legacy name ("minecraft:wool") and colour
+func flattenedColour(name string) (legacy string, colour string, ok bool) {
+	for id, suffix := range colouredBlocks {
+		if !strings.HasPrefix(name, "minecraft:") || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		colour = strings.TrimSuffix(strings.TrimPrefix(name, "minecraft:"), suffix)
+		for _, c := range dyeColours {
+			if c == colour {
+				return NewBlock(id, 0).Name(), colour, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// returns the palette's colour for a biome, looked up by name (current or
+// former) and then id, and false if it hasn't one
+func (p *Palette) BiomeColour(b *Biome) (color.RGBA, bool) {
+	if p == nil {
+		return color.RGBA{}, false
+	}
+	for _, name := range append([]string{b.Name}, b.Aliases...) {
+		if c, ok := p.Biomes[name]; ok {
+			return c, true
+		}
+	}
+	if b.Id < 0 {
+		return color.RGBA{}, false
+	}
+	c, ok := p.Biomes[strconv.Itoa(b.Id)]
+	return c, ok
+}
+
+// sets the colour of blocks matching key, which is a block name optionally
+// followed by properties, eg "minecraft:red_bed[part=head,facing=north]".
+// The namespace defaults to "minecraft"
+func (p *Palette) SetBlock(key string, c color.RGBA) error {
+	name, props := key, ""
+	if i := strings.Index(key, "["); i >= 0 {
+		if !strings.HasSuffix(key, "]") {
+			return fmt.Errorf("%q: missing ]", key)
+		}
+		name, props = key[:i], key[i+1:len(key)-1]
+	}
+	if !strings.Contains(name, ":") {
+		name = "minecraft:" + name
+	}
+	if props == "" {
+		p.Blocks[name] = c
+		return nil
+	}
+	state := BlockState{Properties: make(map[string]string), Colour: c}
+	for _, prop := range strings.Split(props, ",") {
+		kv := strings.SplitN(prop, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("%q: property %q isn't name=value", key, prop)
+		}
+		state.Properties[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	p.States[name] = append(p.States[name], state)
+	return nil
+}
+
+// copies the colours of q over those of p
+func (p *Palette) Merge(q *Palette) {
+	for name, c := range q.Blocks {
+		p.Blocks[name] = c
+	}
+	for name, states := range q.States {
+		p.States[name] = append(p.States[name], states...)
+	}
+	for id, c := range q.Biomes {
+		p.Biomes[id] = c
+	}
+}
+
+// the names to look b up by.  A legacy block is looked up by its old name,
+// unless the flattening reused that for something else, and then by the
+// first of its new names.  Coloured blocks are looked up by the flattened
+// name of their colour, eg "minecraft:red_wool", then the legacy name
+// "minecraft:wool", whichever format they're in
+func paletteNames(b Block) []string {
+	name := b.Name()
+	if b.name != "" {
+		if legacy, _, ok := flattenedColour(name); ok {
+			return []string{name, legacy}
+		}
+		return []string{name}
+	}
+	if suffix, ok := colouredBlocks[b.Id]; ok {
+		// the same as the flattened block of that colour
+		return []string{"minecraft:" + dyeColours[b.Data&15] + suffix, name}
+	}
+	var names []string
+	if id, ok := flattenedIds[name]; !ok || id == b.Id {
+		names = append(names, name)
+	}
+	if flattened, ok := flattenedNames()[b.Id]; ok {
+		names = append(names, flattened)
+	}
+	return names
+}
+
+var flattenedNamesOnce sync.Once
+var flattenedNamesById map[int16]string
+
+func flattenedNames() map[int16]string {
+	flattenedNamesOnce.Do(func() {
+		var names []string
+		for name := range flattenedIds {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		flattenedNamesById = make(map[int16]string)
+		for _, name := range names {
+			if _, ok := flattenedNamesById[flattenedIds[name]]; !ok {
+				flattenedNamesById[flattenedIds[name]] = name
+			}
+		}
+	})
+	return flattenedNamesById
+}
+
+// the form of palette files: colours are "#rrggbb" or "#rrggbbaa", blocks
+// are keyed as for SetBlock, and biomes by name or id
+type paletteFile struct {
+	Source string            `json:"source,omitempty"`
+	Blocks map[string]string `json:"blocks"`
+	Biomes map[string]string `json:"biomes,omitempty"`
+}
+
+// reads a JSON palette file, such as one written by Save.  YAML isn't
+// supported, as it would need a YAML package
+func ReadPalette(fn string) (*Palette, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var pf paletteFile
+	if err := json.Unmarshal(data, &pf); err != nil {
+		return nil, fmt.Errorf("%s: %s", fn, err)
+	}
+	p := NewPalette()
+	for key, s := range pf.Blocks {
+		c, err := ParseColour(s)
+		if err == nil {
+			err = p.SetBlock(key, c)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", fn, key, err)
+		}
+	}
+	for key, s := range pf.Biomes {
+		c, err := ParseColour(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: biome %s: %s", fn, key, err)
+		}
+		if _, err := strconv.Atoi(key); err != nil && !strings.Contains(key, ":") {
+			key = "minecraft:" + key
+		}
+		p.Biomes[key] = c
+	}
+	p.Source = pf.Source
+	return p, nil
+}
+
+// writes the palette as JSON
+func (p *Palette) Save(fn string) error {
+	pf := paletteFile{Source: p.Source, Blocks: make(map[string]string), Biomes: make(map[string]string)}
+	for name, c := range p.Blocks {
+		pf.Blocks[name] = FormatColour(c)
+	}
+	for name, states := range p.States {
+		for _, state := range states {
+			var props []string
+			for k, v := range state.Properties {
+				props = append(props, k+"="+v)
+			}
+			sort.Strings(props)
+			pf.Blocks[name+"["+strings.Join(props, ",")+"]"] = FormatColour(state.Colour)
+		}
+	}
+	for id, c := range p.Biomes {
+		pf.Biomes[id] = FormatColour(c)
+	}
+	data, err := json.MarshalIndent(pf, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(fn, append(data, '\n'))
+}
+
+// parses "#rrggbb" or "#rrggbbaa"; alpha defaults to opaque
+func ParseColour(s string) (c color.RGBA, err error) {
+	c.A = 255
+	switch len(s) {
+	case 7:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	case 9:
+		_, err = fmt.Sscanf(s, "#%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	default:
+		err = fmt.Errorf("colour %q isn't #rrggbb or #rrggbbaa", s)
+	}
+	return
+}
+
+// formats c as "#rrggbb", or "#rrggbbaa" if it isn't opaque
+func FormatColour(c color.RGBA) string {
+	if c.A == 255 {
+		return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+// textures which the game tints by biome are grey; they're given these
+// colours (those of a plains biome) instead
+var textureTints = map[string]color.RGBA{
+	"minecraft:grass_block":     {145, 189, 89, 255},
+	"minecraft:grass":           {145, 189, 89, 255},
+	"minecraft:short_grass":     {145, 189, 89, 255},
+	"minecraft:tall_grass":      {145, 189, 89, 255},
+	"minecraft:fern":            {145, 189, 89, 255},
+	"minecraft:large_fern":      {145, 189, 89, 255},
+	"minecraft:sugar_cane":      {145, 189, 89, 255},
+	"minecraft:oak_leaves":      {119, 171, 47, 255},
+	"minecraft:jungle_leaves":   {119, 171, 47, 255},
+	"minecraft:acacia_leaves":   {119, 171, 47, 255},
+	"minecraft:dark_oak_leaves": {119, 171, 47, 255},
+	"minecraft:mangrove_leaves": {146, 193, 66, 255},
+	"minecraft:vine":            {119, 171, 47, 255},
+	"minecraft:spruce_leaves":   {97, 153, 97, 255},
+	"minecraft:birch_leaves":    {128, 167, 85, 255},
+	"minecraft:lily_pad":        {32, 128, 48, 255},
+	"minecraft:water":           {63, 118, 228, 255},
+}
+
+// the texture variables of a block model which look most like the block
+// seen from above, best first
+var topTextures = []string{"top", "end", "up", "all", "texture", "cross", "plant", "crop", "particle", "side"}
+
+// builds a palette from the textures of client jars, resource pack zips or
+// unpacked resource pack directories.  Files in earlier packs override those
+// in later ones, so a resource pack which only changes some textures should
+// be given before the client jar.  Each block's model is found through its
+// blockstate file, and its colour is the average of the opaque pixels of the
+// texture on its top
+func LoadResourcePack(fns ...string) (*Palette, error) {
+	packs, close, err := openPacks(fns)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+	return paletteFromTextures(packs)
+}
+
+// opens directories or zip files as resource packs, earlier ones first.
+// close closes the zip files
+func openPacks(fns []string) (packs packFS, close func(), err error) {
+	var zips []*zip.ReadCloser
+	close = func() {
+		for _, z := range zips {
+			z.Close()
+		}
+	}
+	for _, fn := range fns {
+		fi, err := os.Stat(fn)
+		if err != nil {
+			close()
+			return nil, nil, err
+		}
+		if fi.IsDir() {
+			packs = append(packs, os.DirFS(fn))
+			continue
+		}
+		z, err := zip.OpenReader(fn)
+		if err != nil {
+			close()
+			return nil, nil, fmt.Errorf("%s: %s", fn, err)
+		}
+		zips = append(zips, z)
+		packs = append(packs, z)
+	}
+	return packs, close, nil
+}
+
+// resource packs layered over each other
+type packFS []fs.FS
+
+func (packs packFS) Open(name string) (f fs.File, err error) {
+	err = &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	for _, pack := range packs {
+		if f, err = pack.Open(name); err == nil {
+			return
+		}
+	}
+	return
+}
+
+// the blockstate files of all the packs
+func (packs packFS) blockstates() ([]string, error) {
+	seen := make(map[string]bool)
+	var all []string
+	for _, pack := range packs {
+		files, err := fs.Glob(pack, "assets/*/blockstates/*.json")
+		if err != nil {
+			return nil, err
+		}
+		for _, fn := range files {
+			if !seen[fn] {
+				seen[fn] = true
+				all = append(all, fn)
+			}
+		}
+	}
+	sort.Strings(all)
+	return all, nil
+}
+
+func paletteFromTextures(packs packFS) (*Palette, error) {
+	blockstates, err := packs.blockstates()
+	if err != nil {
+		return nil, err
+	}
+	if len(blockstates) == 0 {
+		return nil, fmt.Errorf("no assets/*/blockstates found in resource packs")
+	}
+	p := NewPalette()
+	for _, fn := range blockstates {
+		namespace := strings.Split(fn, "/")[1]
+		name := namespace + ":" + strings.TrimSuffix(path.Base(fn), ".json")
+		texture, err := blockTexture(packs, fn)
+		if err != nil || texture == "" {
+			// blocks such as air have no model, and modded packs may be
+			// incomplete; they keep their built in colours
+			continue
+		}
+		c, ok, err := averageTexture(packs, texture)
+		if err != nil || !ok {
+			continue
+		}
+		if tint, ok := textureTints[name]; ok {
+			c = color.RGBA{
+				uint8(int(c.R) * int(tint.R) / 255),
+				uint8(int(c.G) * int(tint.G) / 255),
+				uint8(int(c.B) * int(tint.B) / 255),
+				c.A,
+			}
+		}
+		p.Blocks[name] = c
+	}
+	return p, nil
+}
+
+// splits "ns:path" into its parts; the namespace defaults to "minecraft"
+func resourceLocation(s string) (namespace string, p string) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return "minecraft", s
+}
+
+// returns the location of the texture on the top of the model used by the
+// first variant in a blockstate file
+func blockTexture(fsys fs.FS, blockstate string) (string, error) {
+	data, err := fs.ReadFile(fsys, blockstate)
+	if err != nil {
+		return "", err
+	}
+	var state struct {
+		Variants  map[string]json.RawMessage `json:"variants"`
+		Multipart []struct {
+			Apply json.RawMessage `json:"apply"`
+		} `json:"multipart"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return "", err
+	}
+	// prefer the variant with no properties, or else the first by name
+	var apply json.RawMessage
+	if v, ok := state.Variants[""]; ok {
+		apply = v
+	} else if len(state.Variants) > 0 {
+		var keys []string
+		for k := range state.Variants {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		apply = state.Variants[keys[0]]
+	} else if len(state.Multipart) > 0 {
+		apply = state.Multipart[0].Apply
+	} else {
+		return "", nil
+	}
+	// either a model or a list of weighted alternatives
+	var model struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(apply, &model); err != nil {
+		var models []struct {
+			Model string `json:"model"`
+		}
+		if err := json.Unmarshal(apply, &models); err != nil || len(models) == 0 {
+			return "", fmt.Errorf("%s: can't find a model", blockstate)
+		}
+		model = models[0]
+	}
+	textures, err := modelTextures(fsys, model.Model)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range topTextures {
+		if t, ok := textures[v]; ok {
+			return t, nil
+		}
+	}
+	return "", nil
+}
+
+// returns a model's texture variables, including those inherited from its
+// parents, with references to other variables ("#all") resolved
+func modelTextures(fsys fs.FS, model string) (map[string]string, error) {
+	textures := make(map[string]string)
+	named := model
+	for depth := 0; model != "" && depth < 16; depth++ {
+		namespace, p := resourceLocation(model)
+		if !strings.Contains(p, "/") {
+			// before 1.13 models weren't in a directory
+			p = "block/" + p
+		}
+		data, err := fs.ReadFile(fsys, "assets/"+namespace+"/models/"+p+".json")
+		if err != nil {
+			// the root models, such as block/block, are often left out
+			// of resource packs, and don't have any textures anyway
+			if model == named {
+				return nil, err
+			}
+			break
+		}
+		var m struct {
+			Parent   string            `json:"parent"`
+			Textures map[string]string `json:"textures"`
+		}
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("%s: %s", model, err)
+		}
+		for k, v := range m.Textures {
+			if _, ok := textures[k]; !ok {
+				textures[k] = v
+			}
+		}
+		model = m.Parent
+	}
+	for k, v := range textures {
+		for i := 0; strings.HasPrefix(v, "#") && i < 16; i++ {
+			v = textures[v[1:]]
+		}
+		if strings.HasPrefix(v, "#") || v == "" {
+			delete(textures, k)
+		} else {
+			textures[k] = v
+		}
+	}
+	return textures, nil
+}
+
+// the average colour of the pixels of a texture which aren't transparent.
+// Animated textures are a strip of frames, all of which are included
+func averageTexture(fsys fs.FS, texture string) (c color.RGBA, ok bool, err error) {
+	namespace, p := resourceLocation(texture)
+	f, err := fsys.Open("assets/" + namespace + "/textures/" + p + ".png")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return
+	}
+	var r, g, b, n uint64
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if pixel.A < 128 {
+				continue
+			}
+			r += uint64(pixel.R)
+			g += uint64(pixel.G)
+			b += uint64(pixel.B)
+			n++
+		}
+	}
+	if n == 0 {
+		return
+	}
+	return color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255}, true, nil
+}
@@ -0,0 +1,148 @@
+package mapper
+
+import "context"
+import "fmt"
+import "runtime"
+import "sync"
+
+// a chunk which couldn't be read, parsed or rendered by a Pipeline
+type ChunkError struct {
+	Region string // filename
+	X      int    // world chunk coordinates
+	Z      int
+	Err    error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("%s: chunk %d,%d: %s", e.Region, e.X, e.Z, e.Err)
+}
+
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// A Pipeline reads the chunks of region files and hands them to a pool of
+// workers.  One goroutine reads the files, so that they're read in order,
+// while the workers decode the chunks and render them however the program
+// likes
+type Pipeline struct {
+	// the number of chunks decoded and rendered at once; 0 means one per
+	// CPU
+	Workers int
+	// if not nil, only chunks (by world chunk x z) for which this returns
+	// true are read
+	Filter func(x int, z int) bool
+	// called with chunks which fail, which are then skipped.  If nil they
+	// are skipped silently.  May be called from several goroutines at once
+	Error func(err *ChunkError)
+	// if not nil, called as each region file is opened
+	Reading func(fn string)
+	// if not nil, a worker sends to this before decoding and rendering each
+	// chunk and receives from it after, so Pipelines sharing it decode and
+	// render no more than its capacity of chunks at once between them
+	Limit chan struct{}
+}
+
+// a chunk read but not decoded yet
+type pipelineJob struct {
+	fn     string
+	region *Region
+	x      int // within the region
+	z      int
+	data   []byte
+}
+
+// reads every chunk of files and calls render with each, from Workers
+// goroutines at once, until they're all done or ctx is cancelled.  Region
+// files which can't be opened are passed to Error like chunks and skipped.
+// Returns ctx's error if it was cancelled
+func (p *Pipeline) Run(ctx context.Context, files []string, render func(c *Chunk) error) error {
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	jobs := make(chan pipelineJob, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if ctx.Err() != nil {
+					// drain, so the reader isn't left blocked
+					continue
+				}
+				if p.Limit != nil {
+					select {
+					case p.Limit <- struct{}{}:
+					case <-ctx.Done():
+						continue
+					}
+				}
+				c, err := ParseChunk(job.data, job.region, job.x, job.z)
+				if err == nil {
+					err = render(c)
+				}
+				if p.Limit != nil {
+					<-p.Limit
+				}
+				if err != nil {
+					p.fail(job.fn, job.region, job.x, job.z, err)
+				}
+			}
+		}()
+	}
+	err := p.read(ctx, files, jobs)
+	close(jobs)
+	wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// sends the chunks of each of files to jobs, closing each region file once
+// its chunks are read.  Chunks only need their region for its coordinates
+func (p *Pipeline) read(ctx context.Context, files []string, jobs chan<- pipelineJob) error {
+	for _, fn := range files {
+		if p.Reading != nil {
+			p.Reading(fn)
+		}
+		r := new(Region)
+		if err := r.Open(fn); err != nil {
+			// reported as the region's first chunk, which is as
+			// near as a ChunkError gets to the whole region
+			p.fail(fn, r, 0, 0, err)
+			continue
+		}
+		for x := 0; x < 32; x++ {
+			for z := 0; z < 32; z++ {
+				if p.Filter != nil && !p.Filter(r.X*32+x, r.Z*32+z) {
+					continue
+				}
+				data, err := r.ChunkData(x, z)
+				if err != nil {
+					p.fail(fn, r, x, z, err)
+					continue
+				}
+				if data.Len() == 0 {
+					continue
+				}
+				select {
+				case jobs <- pipelineJob{fn, r, x, z, data.Bytes()}:
+				case <-ctx.Done():
+					r.Close()
+					return ctx.Err()
+				}
+			}
+		}
+		r.Close()
+	}
+	return nil
+}
+
+func (p *Pipeline) fail(fn string, r *Region, x int, z int, err error) {
+	if p.Error != nil {
+		p.Error(&ChunkError{fn, r.X*32 + x, r.Z*32 + z, err})
+	}
+}
@@ -0,0 +1,121 @@
+package mapper
+
+import "fmt"
+import "os"
+import "time"
+
+// decides whether a chunk of region r should be removed by Prune
+type PrunePredicate func(r *Region, c *Chunk) (bool, error)
+
+// matches chunks which players have spent less than ticks in (20 per second)
+func InhabitedBelow(ticks int64) PrunePredicate {
+	return func(r *Region, c *Chunk) (bool, error) {
+		t, err := c.InhabitedTime()
+		return t < ticks, err
+	}
+}
+
+// matches chunks entirely outside the box with corners at block coordinates
+// x1 z1 and x2 z2 (inclusive)
+func OutsideBox(x1 int, z1 int, x2 int, z2 int) PrunePredicate {
+	if x1 > x2 {
+		x1, x2 = x2, x1
+	}
+	if z1 > z2 {
+		z1, z2 = z2, z1
+	}
+	return func(r *Region, c *Chunk) (bool, error) {
+		return c.X()*16+15 < x1 || c.X()*16 > x2 || c.Z()*16+15 < z1 || c.Z()*16 > z2, nil
+	}
+}
+
+// matches chunks which were last saved before t
+func OlderThan(t time.Time) PrunePredicate {
+	return func(r *Region, c *Chunk) (bool, error) {
+		return r.Timestamp(c.chunkX, c.chunkZ).Before(t), nil
+	}
+}
+
+// matches chunks which all of preds match
+func AllOf(preds ...PrunePredicate) PrunePredicate {
+	return func(r *Region, c *Chunk) (bool, error) {
+		for _, p := range preds {
+			if ok, err := p(r, c); !ok || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// matches chunks which any of preds match
+func AnyOf(preds ...PrunePredicate) PrunePredicate {
+	return func(r *Region, c *Chunk) (bool, error) {
+		for _, p := range preds {
+			if ok, err := p(r, c); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+}
+
+// returns the chunks of r which pred matches, and the number which it
+// doesn't
+func MatchingChunks(r *Region, pred PrunePredicate) (matched []ChunkInfo, unmatched int, err error) {
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			chunkData, err := r.ChunkData(x, z)
+			if err != nil {
+				return nil, 0, fmt.Errorf("chunk %d,%d: %s", x, z, err)
+			}
+			if chunkData.Len() == 0 {
+				continue
+			}
+			c, err := ParseChunk(chunkData.Bytes(), r, x, z)
+			if err != nil {
+				return nil, 0, fmt.Errorf("chunk %d,%d: %s", x, z, err)
+			}
+			ok, err := pred(r, c)
+			if err != nil {
+				return nil, 0, fmt.Errorf("chunk %d,%d: %s", x, z, err)
+			}
+			if ok {
+				info, _ := r.ChunkInfo(x, z)
+				matched = append(matched, info)
+			} else {
+				unmatched++
+			}
+		}
+	}
+	return
+}
+
+// removes the chunks of region file fn which pred matches and rewrites it
+// without the space they used.  If no chunks are left the file is removed.
+// Nothing is changed if any chunk can't be read or tested
+func Prune(fn string, pred PrunePredicate) (removed int, err error) {
+	r := new(Region)
+	if err = r.Open(fn); err != nil {
+		return
+	}
+	matched, unmatched, err := MatchingChunks(r, pred)
+	r.Close()
+	if err != nil || len(matched) == 0 {
+		return
+	}
+	w, err := NewRegionWriter(fn)
+	if err != nil {
+		return
+	}
+	for _, info := range matched {
+		w.DeleteChunk(info.X, info.Z)
+	}
+	if err = w.Save(); err != nil {
+		return
+	}
+	if unmatched == 0 {
+		err = os.Remove(fn)
+	}
+	return len(matched), err
+}
@@ -1,12 +1,15 @@
 package mapper
 
 import "bytes"
+import "compress/gzip"
 import "compress/zlib"
 import "encoding/binary"
+import "errors"
 import "fmt"
 import "io"
 import "os"
 import "path"
+import "path/filepath"
 import "strconv"
 import "strings"
 import "time"
@@ -21,28 +24,42 @@ type Region struct {
 
 func (r *Region) Open(fn string) (err error) {
 	r.filename = fn
-	components := strings.Split(path.Base(fn), ".")
-	x, err := strconv.ParseInt(components[1], 10, 64)
-	z, err := strconv.ParseInt(components[2], 10, 64)
-	r.X = int(x)
-	r.Z = int(z)
+	if r.X, r.Z, err = parseRegionFilename(fn); err != nil {
+		return err
+	}
 	//fmt.Printf("Opening %s (x=%d, z=%d)\n", fn, r.X, r.Z)
 	file, err := os.Open(fn)
 	if err != nil {
 		return err
 	}
-	r.file = file
 	// read the header
-	n, err := r.file.Read(r.header[:])
+	n, err := file.Read(r.header[:])
+	if err == nil && n != 8192 {
+		err = fmt.Errorf("open: header size %d != 8192", n)
+	}
 	if err != nil {
+		file.Close()
 		return err
 	}
-	if n != 8192 {
-		return fmt.Errorf("open: header size %d != 8192", n)
-	}
+	r.file = file
 	return nil
 }
 
+// region files are named r.X.Z.mca
+func parseRegionFilename(fn string) (x int, z int, err error) {
+	components := strings.Split(path.Base(fn), ".")
+	if len(components) != 4 || components[0] != "r" {
+		err = fmt.Errorf("%s: not a region filename", fn)
+		return
+	}
+	x64, err := strconv.ParseInt(components[1], 10, 64)
+	if err != nil {
+		return
+	}
+	z64, err := strconv.ParseInt(components[2], 10, 64)
+	return int(x64), int(z64), err
+}
+
 func header_offset(x int, z int) int {
 	return 4 * ((x % 32) + (z%32)*32)
 
@@ -58,36 +75,177 @@ func (r *Region) chunk_location(x int, z int) (location int, length int) {
 	return
 }
 
+// how a chunk's data is compressed in the region file
+type Compression byte
+
+const (
+	CompressionGzip Compression = 1
+	CompressionZlib Compression = 2
+	CompressionNone Compression = 3
+	CompressionLZ4  Compression = 4 // 1.20.5+, if configured on the server
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZlib:
+		return "zlib"
+	case CompressionNone:
+		return "none"
+	case CompressionLZ4:
+		return "lz4"
+	}
+	return fmt.Sprintf("unknown(%d)", byte(c))
+}
+
+// describes where and how a chunk is stored in a region file
+type ChunkInfo struct {
+	X           int // 0-31 within the region
+	Z           int
+	Sector      int // offset in 4KiB sectors; 0 if the chunk isn't present
+	Sectors     int // number of sectors allocated to the chunk
+	Length      int // bytes of compressed data
+	Compression Compression
+	External    bool // data is in a .mcc file because it's over 1MiB
+	Timestamp   time.Time
+}
+
+// reads the location header and the 5 byte header at the start of the
+// chunk's sectors: a (big-endian) length of the data which follows, and its
+// compression type
+func (r *Region) ChunkInfo(x int, z int) (info ChunkInfo, err error) {
+	info.X, info.Z = x, z
+	info.Sector, info.Sectors = r.chunk_location(x, z)
+	if info.Sector == 0 {
+		return
+	}
+	info.Timestamp = r.Timestamp(x, z)
+	var chunk_header [5]byte
+	if _, err = r.file.ReadAt(chunk_header[:], int64(info.Sector*4096)); err != nil {
+		err = fmt.Errorf("chunk_info %d,%d: %s", x, z, err)
+		return
+	}
+	// the length includes the compression type byte
+	info.Length = int(binary.BigEndian.Uint32(chunk_header[0:4])) - 1
+	info.Compression = Compression(chunk_header[4] &^ 0x80)
+	if info.Length < 0 || info.Length+5 > info.Sectors*4096 {
+		err = fmt.Errorf("chunk_info %d,%d: length %d doesn't fit in %d sectors", x, z, info.Length, info.Sectors)
+		return
+	}
+	if chunk_header[4]&0x80 != 0 {
+		// the high bit means the data is in c.X.Z.mcc beside the region
+		// file, with nothing but the type stored here
+		info.External = true
+		var fi os.FileInfo
+		if fi, err = os.Stat(r.externalFilename(x, z)); err != nil {
+			err = fmt.Errorf("chunk_info %d,%d: %s", x, z, err)
+			return
+		}
+		info.Length = int(fi.Size())
+	}
+	return
+}
+
+// the name of the file which holds an oversized chunk.  Unlike the region
+// file it's named after the chunk's world coordinates
+func (r *Region) externalFilename(x int, z int) string {
+	return filepath.Join(filepath.Dir(r.filename), fmt.Sprintf("c.%d.%d.mcc", r.X*32+x, r.Z*32+z))
+}
+
 // returns a Buffer which is the uncompressed chunk data
 func (r *Region) ChunkData(x int, z int) (data bytes.Buffer, err error) {
-	var chunk_header [5]byte
-	location, _ := r.chunk_location(x, z)
-	if location == 0 {
+	raw, err := r.rawChunk(x, z)
+	if err != nil || raw == nil {
 		// chunk isn't present in file, return empty buffer
 		return
 	}
-	r.file.Seek(int64(location*4096), io.SeekStart)
-	r.file.Read(chunk_header[:])
-	length := binary.BigEndian.Uint32(chunk_header[0:4])
-	compression_type := int(chunk_header[4])
-	if compression_type != 2 {
-		err = fmt.Errorf("chunk_data: unsupported compressed type %d", compression_type)
+	err = decompress(raw.compression, raw.data, &data)
+	//fmt.Printf("length uncompressed: %d\n", data.Len())
+	return
+}
+
+// a chunk's data as stored in a region file
+type rawChunk struct {
+	compression Compression
+	data        []byte // compressed
+	timestamp   time.Time
+	external    bool // read from a .mcc file
+}
+
+// returns the still compressed data of a chunk, or nil if it isn't present
+func (r *Region) rawChunk(x int, z int) (*rawChunk, error) {
+	info, err := r.ChunkInfo(x, z)
+	if err != nil || info.Sector == 0 {
+		return nil, err
+	}
+	//fmt.Printf("length of compressed data = %d\n", info.Length)
+	var compressed []byte
+	if info.External {
+		if compressed, err = os.ReadFile(r.externalFilename(x, z)); err != nil {
+			return nil, err
+		}
+	} else {
+		compressed = make([]byte, info.Length)
+		if _, err = r.file.ReadAt(compressed, int64(info.Sector*4096+5)); err != nil {
+			return nil, err
+		}
+	}
+	return &rawChunk{info.Compression, compressed, info.Timestamp, info.External}, nil
+}
+
+func decompress(compression Compression, compressed []byte, data *bytes.Buffer) (err error) {
+	var reader io.Reader
+	switch compression {
+	case CompressionGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(compressed))
+	case CompressionZlib:
+		reader, err = zlib.NewReader(bytes.NewReader(compressed))
+	case CompressionNone:
+		reader = bytes.NewReader(compressed)
+	case CompressionLZ4:
+		var out []byte
+		out, err = decompressLZ4Stream(compressed)
+		data.Write(out)
 		return
+	default:
+		return fmt.Errorf("chunk_data: unsupported compression type %d", compression)
 	}
-	//fmt.Printf("length of compressed data = %d\n", length)
-	compressed := make([]byte, length)
-	_, err = r.file.Read(compressed[:])
 	if err != nil {
 		return
 	}
-	//fmt.Printf("length compressed: %d\n", n)
-	reader, _ := zlib.NewReader(bytes.NewBuffer(compressed[:]))
-	io.Copy(&data, reader)
-	//fmt.Printf("length uncompressed: %d\n", data.Len())
+	// a truncated stream is reported here
+	_, err = io.Copy(data, reader)
 	return
 }
 
-func (r *Region) chunk_timestamp(x int, z int) time.Time {
+func (r *Region) Close() error {
+	return r.file.Close()
+}
+
+// returns info for each chunk present in the region, in header order (x
+// varying fastest).  Chunks whose info can't be read are left out, and their
+// errors returned together once the rest have been listed
+func (r *Region) Chunks() (chunks []ChunkInfo, err error) {
+	var errs []error
+	for z := 0; z < 32; z++ {
+		for x := 0; x < 32; x++ {
+			info, err := r.ChunkInfo(x, z)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if info.Sector != 0 {
+				chunks = append(chunks, info)
+			}
+		}
+	}
+	return chunks, errors.Join(errs...)
+}
+
+// the time the chunk at x z was last saved, from the second 4KiB of the
+// header
+func (r *Region) Timestamp(x int, z int) time.Time {
 	offset := header_offset(x, z) + 4096
 	bytes := r.header[offset : offset+4]
 	uts := binary.BigEndian.Uint32(bytes)
@@ -0,0 +1,160 @@
+package mapper
+
+import "fmt"
+
+import "github.com/timocp/nbt"
+
+// checked accessors for the children of a compound tag, returning a
+// MissingTagError or TagTypeError instead of panicking on unexpected data
+
+func child(parent nbt.CompoundTag, name string) (nbt.Tag, error) {
+	t := parent.ChildByName(name)
+	if t == nil {
+		return nil, &MissingTagError{name}
+	}
+	return t, nil
+}
+
+func tagTypeError(name string, want interface{}, got nbt.Tag) error {
+	return &TagTypeError{name, fmt.Sprintf("%T", want), fmt.Sprintf("%T", got)}
+}
+
+func compoundChild(parent nbt.CompoundTag, name string) (v nbt.CompoundTag, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	v, ok := t.(nbt.CompoundTag)
+	if !ok {
+		err = tagTypeError(name, v, t)
+	}
+	return
+}
+
+func listChild(parent nbt.CompoundTag, name string) (v nbt.ListTag, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	v, ok := t.(nbt.ListTag)
+	if !ok {
+		err = tagTypeError(name, v, t)
+	}
+	return
+}
+
+func byteArrayChild(parent nbt.CompoundTag, name string) (v []byte, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	a, ok := t.(nbt.ByteArrayTag)
+	if !ok {
+		return nil, tagTypeError(name, a, t)
+	}
+	return a.Values, nil
+}
+
+func intArrayChild(parent nbt.CompoundTag, name string) (v []int32, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	a, ok := t.(nbt.IntArrayTag)
+	if !ok {
+		return nil, tagTypeError(name, a, t)
+	}
+	return a.Values, nil
+}
+
+func longArrayChild(parent nbt.CompoundTag, name string) (v []int64, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	a, ok := t.(nbt.LongArrayTag)
+	if !ok {
+		return nil, tagTypeError(name, a, t)
+	}
+	return a.Values, nil
+}
+
+func stringChild(parent nbt.CompoundTag, name string) (v string, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	s, ok := t.(nbt.StringTag)
+	if !ok {
+		return "", tagTypeError(name, s, t)
+	}
+	return s.Value, nil
+}
+
+func byteChild(parent nbt.CompoundTag, name string) (v int, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	b, ok := t.(nbt.ByteTag)
+	if !ok {
+		return 0, tagTypeError(name, b, t)
+	}
+	return int(b.Value), nil
+}
+
+func intChild(parent nbt.CompoundTag, name string) (v int, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	i, ok := t.(nbt.IntTag)
+	if !ok {
+		return 0, tagTypeError(name, i, t)
+	}
+	return int(i.Value), nil
+}
+
+func longChild(parent nbt.CompoundTag, name string) (v int64, err error) {
+	t, err := child(parent, name)
+	if err != nil {
+		return
+	}
+	l, ok := t.(nbt.LongTag)
+	if !ok {
+		return 0, tagTypeError(name, l, t)
+	}
+	return l.Value, nil
+}
+
+// like the accessors above, but a missing tag isn't an error and leaves *v
+// unchanged
+
+func optionalString(parent nbt.CompoundTag, name string, v *string) (err error) {
+	if parent.ChildByName(name) != nil {
+		*v, err = stringChild(parent, name)
+	}
+	return
+}
+
+func optionalInt(parent nbt.CompoundTag, name string, v *int) (err error) {
+	if parent.ChildByName(name) != nil {
+		*v, err = intChild(parent, name)
+	}
+	return
+}
+
+func optionalLong(parent nbt.CompoundTag, name string, v *int64) (err error) {
+	if parent.ChildByName(name) != nil {
+		*v, err = longChild(parent, name)
+	}
+	return
+}
+
+// a missing compound is returned as an empty one
+func optionalCompound(parent nbt.CompoundTag, name string) (v nbt.CompoundTag, err error) {
+	if parent.ChildByName(name) != nil {
+		v, err = compoundChild(parent, name)
+	}
+	return
+}
@@ -0,0 +1,257 @@
+package mapper
+
+import "fmt"
+import "image"
+import "image/color"
+import "io/fs"
+import "path"
+
+// which of its biome's colours the game tints a block with
+type Tint int
+
+const (
+	TintNone Tint = iota
+	TintGrass
+	TintFoliage
+	TintWater
+)
+
+// returns how b is tinted.  Spruce and birch leaves have fixed colours, so
+// aren't tinted
+func BlockTint(b Block) Tint {
+	if b.name != "" {
+		switch b.name {
+		case "minecraft:grass_block", "minecraft:grass", "minecraft:short_grass", "minecraft:tall_grass",
+			"minecraft:fern", "minecraft:large_fern", "minecraft:sugar_cane":
+			return TintGrass
+		case "minecraft:oak_leaves", "minecraft:jungle_leaves", "minecraft:acacia_leaves",
+			"minecraft:dark_oak_leaves", "minecraft:mangrove_leaves", "minecraft:vine":
+			return TintFoliage
+		case "minecraft:water", "minecraft:bubble_column":
+			return TintWater
+		}
+		return TintNone
+	}
+	switch b.Id {
+	case Grass, Tallgrass, Reeds:
+		return TintGrass
+	case Double_plant:
+		// the lower half of double tallgrass and large ferns
+		if b.Data&7 == 2 || b.Data&7 == 3 {
+			return TintGrass
+		}
+	case Leaves:
+		if b.Data&3 == 0 || b.Data&3 == 3 {
+			return TintFoliage
+		}
+	case Leaves2, Vine:
+		return TintFoliage
+	case Water, Flowing_water:
+		return TintWater
+	}
+	return TintNone
+}
+
+// A Tinter works out the colours of grass and foliage in each biome from the
+// game's colormaps, which are indexed by temperature and downfall.  A nil
+// Tinter, or one without colormaps, uses an approximation of the vanilla ones
+type Tinter struct {
+	Grass   image.Image
+	Foliage image.Image
+}
+
+// the corners of the vanilla colormaps: hot and wet, hot and dry, and cold
+var (
+	grassCorners   = [3]color.RGBA{{71, 205, 51, 255}, {191, 183, 85, 255}, {128, 180, 151, 255}}
+	foliageCorners = [3]color.RGBA{{26, 191, 0, 255}, {174, 164, 42, 255}, {96, 161, 123, 255}}
+)
+
+// biomes whose grass and foliage don't come from the colormaps
+var (
+	grassOverrides = map[string]color.RGBA{
+		"minecraft:swamp":                            rgb(0x6a7039),
+		"minecraft:swamp_hills":                      rgb(0x6a7039),
+		"minecraft:mangrove_swamp":                   rgb(0x6a7039),
+		"minecraft:badlands":                         rgb(0x90814d),
+		"minecraft:wooded_badlands":                  rgb(0x90814d),
+		"minecraft:badlands_plateau":                 rgb(0x90814d),
+		"minecraft:eroded_badlands":                  rgb(0x90814d),
+		"minecraft:modified_wooded_badlands_plateau": rgb(0x90814d),
+		"minecraft:modified_badlands_plateau":        rgb(0x90814d),
+		"minecraft:pale_garden":                      rgb(0x778272),
+	}
+	foliageOverrides = map[string]color.RGBA{
+		"minecraft:swamp":                            rgb(0x6a7039),
+		"minecraft:swamp_hills":                      rgb(0x6a7039),
+		"minecraft:mangrove_swamp":                   rgb(0x8db127),
+		"minecraft:badlands":                         rgb(0x9e814d),
+		"minecraft:wooded_badlands":                  rgb(0x9e814d),
+		"minecraft:badlands_plateau":                 rgb(0x9e814d),
+		"minecraft:eroded_badlands":                  rgb(0x9e814d),
+		"minecraft:modified_wooded_badlands_plateau": rgb(0x9e814d),
+		"minecraft:modified_badlands_plateau":        rgb(0x9e814d),
+		"minecraft:pale_garden":                      rgb(0x878d76),
+	}
+	// since 1.13 water is coloured by biome too
+	waterColours = map[string]color.RGBA{
+		"minecraft:swamp":               rgb(0x617b64),
+		"minecraft:swamp_hills":         rgb(0x617b64),
+		"minecraft:mangrove_swamp":      rgb(0x3a7a6a),
+		"minecraft:warm_ocean":          rgb(0x43d5ee),
+		"minecraft:deep_warm_ocean":     rgb(0x43d5ee),
+		"minecraft:lukewarm_ocean":      rgb(0x45adf2),
+		"minecraft:deep_lukewarm_ocean": rgb(0x45adf2),
+		"minecraft:cold_ocean":          rgb(0x3d57d6),
+		"minecraft:deep_cold_ocean":     rgb(0x3d57d6),
+		"minecraft:snowy_plains":        rgb(0x3d57d6),
+		"minecraft:snowy_beach":         rgb(0x3d57d6),
+		"minecraft:snowy_taiga":         rgb(0x3d57d6),
+		"minecraft:snowy_taiga_hills":   rgb(0x3d57d6),
+		"minecraft:ice_spikes":          rgb(0x3d57d6),
+		"minecraft:frozen_ocean":        rgb(0x3938c9),
+		"minecraft:deep_frozen_ocean":   rgb(0x3938c9),
+		"minecraft:frozen_river":        rgb(0x3938c9),
+		"minecraft:meadow":              rgb(0x0e4ecf),
+		"minecraft:cherry_grove":        rgb(0x5db7ef),
+		"minecraft:pale_garden":         rgb(0x76889d),
+	}
+	defaultWater = rgb(0x3f76e4)
+)
+
+// the colour of b's water
+func (b *Biome) WaterColour() color.RGBA {
+	if c, ok := waterColours[b.Name]; ok {
+		return c
+	}
+	return defaultWater
+}
+
+// returns the colour the game tints blocks of kind with in biome b
+func (t *Tinter) Colour(kind Tint, b *Biome) color.RGBA {
+	switch kind {
+	case TintGrass:
+		if c, ok := grassOverrides[b.Name]; ok {
+			return c
+		}
+		var colormap image.Image
+		if t != nil {
+			colormap = t.Grass
+		}
+		c := colormapColour(colormap, grassCorners, b)
+		if b.Name == "minecraft:dark_forest" || b.Name == "minecraft:dark_forest_hills" {
+			// averaged with a dark green
+			c = color.RGBA{uint8((int(c.R) + 0x28) / 2), uint8((int(c.G) + 0x34) / 2), uint8((int(c.B) + 0x0a) / 2), 255}
+		}
+		return c
+	case TintFoliage:
+		if c, ok := foliageOverrides[b.Name]; ok {
+			return c
+		}
+		var colormap image.Image
+		if t != nil {
+			colormap = t.Foliage
+		}
+		return colormapColour(colormap, foliageCorners, b)
+	case TintWater:
+		return b.WaterColour()
+	}
+	return color.RGBA{255, 255, 255, 255}
+}
+
+// looks up a biome in a colormap, or interpolates between the corners of the
+// vanilla one if it's nil.  Downfall is scaled by temperature, so only the
+// lower left triangle of the map is used
+func colormapColour(colormap image.Image, corners [3]color.RGBA, b *Biome) color.RGBA {
+	temperature := clamp01(b.Temperature)
+	downfall := clamp01(b.Downfall) * temperature
+	if colormap != nil {
+		bounds := colormap.Bounds()
+		x := bounds.Min.X + int((1-temperature)*float64(bounds.Dx()-1))
+		y := bounds.Min.Y + int((1-downfall)*float64(bounds.Dy()-1))
+		c := color.NRGBAModel.Convert(colormap.At(x, y)).(color.NRGBA)
+		return color.RGBA{c.R, c.G, c.B, 255}
+	}
+	weights := [3]float64{downfall, temperature - downfall, 1 - temperature}
+	var r, g, bl float64
+	for i, c := range corners {
+		r += weights[i] * float64(c.R)
+		g += weights[i] * float64(c.G)
+		bl += weights[i] * float64(c.B)
+	}
+	return color.RGBA{uint8(r + 0.5), uint8(g + 0.5), uint8(bl + 0.5), 255}
+}
+
+func clamp01(v float64) float64 {
+	if v < 0 {
+		return 0
+	} else if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// the biome whose colours textures are tinted with in palettes made by
+// LoadResourcePack.  (BiomeById can't be used before init)
+var plainsBiome = &Biomes[1]
+
+// returns the colour of b in biome, tinted as the game would.  Colours from
+// the palette are taken to be those of plains, and are shifted by the
+// difference between the two biomes; built in colours are replaced by the
+// biome's.  A nil biome leaves the colour as it is
+func (t *Tinter) BlockColour(p *Palette, b Block, biome *Biome) color.RGBA {
+	kind := BlockTint(b)
+	if kind == TintNone || biome == nil {
+		return p.Colour(b)
+	}
+	tint := t.Colour(kind, biome)
+	c, ok := p.lookup(b)
+	if !ok {
+		return tint
+	}
+	plains := t.Colour(kind, plainsBiome)
+	shift := func(v uint8, to uint8, from uint8) uint8 {
+		if from == 0 {
+			return v
+		}
+		s := int(v) * int(to) / int(from)
+		if s > 255 {
+			s = 255
+		}
+		return uint8(s)
+	}
+	return color.RGBA{shift(c.R, tint.R, plains.R), shift(c.G, tint.G, plains.G), shift(c.B, tint.B, plains.B), c.A}
+}
+
+// reads the grass and foliage colormaps from the first of fns which has them.
+// Each may be a directory holding grass.png and foliage.png, or a client jar
+// or resource pack
+func LoadColormaps(fns ...string) (*Tinter, error) {
+	packs, close, err := openPacks(fns)
+	if err != nil {
+		return nil, err
+	}
+	defer close()
+	t := new(Tinter)
+	for _, dir := range []string{".", "assets/minecraft/textures/colormap"} {
+		if t.Grass == nil {
+			t.Grass, _ = decodeImage(packs, path.Join(dir, "grass.png"))
+		}
+		if t.Foliage == nil {
+			t.Foliage, _ = decodeImage(packs, path.Join(dir, "foliage.png"))
+		}
+	}
+	if t.Grass == nil && t.Foliage == nil {
+		return nil, fmt.Errorf("no grass.png or foliage.png colormap in %s", fns)
+	}
+	return t, nil
+}
+
+func decodeImage(fsys fs.FS, fn string) (image.Image, error) {
+	f, err := fsys.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
@@ -0,0 +1,237 @@
+package mapper
+
+import "bytes"
+import "fmt"
+
+// the kinds of problem Verify looks for
+type ProblemKind int
+
+const (
+	// the chunk's sectors overlap the 8KiB header
+	ProblemInHeader ProblemKind = iota
+	// the chunk's sectors extend past the end of the file
+	ProblemPastEOF
+	// the chunk shares sectors with another chunk
+	ProblemOverlap
+	// the length at the start of the chunk disagrees with the header
+	ProblemLength
+	// the compression type is unknown
+	ProblemCompression
+	// the external .mcc file is missing or unreadable
+	ProblemExternal
+	// the data doesn't decompress, eg a truncated zlib stream
+	ProblemCorrupt
+	// the decompressed data isn't a valid chunk
+	ProblemNBT
+	// the chunk says it belongs at different coordinates
+	ProblemPosition
+)
+
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemInHeader:
+		return "sectors overlap header"
+	case ProblemPastEOF:
+		return "sectors past end of file"
+	case ProblemOverlap:
+		return "sectors overlap another chunk"
+	case ProblemLength:
+		return "bad length"
+	case ProblemCompression:
+		return "unknown compression"
+	case ProblemExternal:
+		return "bad external file"
+	case ProblemCorrupt:
+		return "corrupt data"
+	case ProblemNBT:
+		return "bad nbt"
+	case ProblemPosition:
+		return "wrong position"
+	}
+	return fmt.Sprintf("ProblemKind(%d)", int(k))
+}
+
+// a problem with one chunk of a region file
+type Problem struct {
+	X      int // 0-31 within the region
+	Z      int
+	Kind   ProblemKind
+	Detail string
+}
+
+func (p Problem) String() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("chunk %d,%d: %s", p.X, p.Z, p.Kind)
+	}
+	return fmt.Sprintf("chunk %d,%d: %s: %s", p.X, p.Z, p.Kind, p.Detail)
+}
+
+// the result of checking one chunk
+type chunkCheck struct {
+	problems []Problem
+	raw      *rawChunk // nil unless the data could be read
+	chunk    *Chunk    // nil unless it parsed
+}
+
+// true if the chunk was read and parsed, even if it has other problems such
+// as being in the wrong place
+func (cc *chunkCheck) readable() bool {
+	return cc.chunk != nil
+}
+
+// checks every chunk in the region, returning the problems found in order of
+// position in the header
+func (r *Region) Verify() ([]Problem, error) {
+	checks, err := r.check()
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	for _, cc := range checks {
+		if cc != nil {
+			problems = append(problems, cc.problems...)
+		}
+	}
+	return problems, nil
+}
+
+// checks each chunk in the region, by header_offset/4.  Entries for chunks
+// which aren't present are nil
+func (r *Region) check() (checks [1024]*chunkCheck, err error) {
+	fi, err := r.file.Stat()
+	if err != nil {
+		return
+	}
+	fileSectors := int((fi.Size() + 4095) / 4096)
+	// which chunk (by header_offset/4 + 1) first claimed each sector
+	owners := make([]int, fileSectors)
+	for z := 0; z < 32; z++ {
+		for x := 0; x < 32; x++ {
+			location, sectors := r.chunk_location(x, z)
+			if location == 0 {
+				continue
+			}
+			cc := new(chunkCheck)
+			checks[header_offset(x, z)/4] = cc
+			problem := func(kind ProblemKind, format string, a ...interface{}) {
+				cc.problems = append(cc.problems, Problem{x, z, kind, fmt.Sprintf(format, a...)})
+			}
+			if location < 2 {
+				problem(ProblemInHeader, "sector %d", location)
+				continue
+			}
+			if sectors == 0 {
+				problem(ProblemLength, "0 sectors")
+				continue
+			}
+			if location+sectors > fileSectors {
+				problem(ProblemPastEOF, "sectors %d-%d, file has %d", location, location+sectors-1, fileSectors)
+				continue
+			}
+			for s := location; s < location+sectors; s++ {
+				if owners[s] != 0 {
+					other := owners[s] - 1
+					problem(ProblemOverlap, "with chunk %d,%d at sector %d", other%32, other/32, s)
+					break
+				}
+				owners[s] = header_offset(x, z)/4 + 1
+			}
+			info, err := r.ChunkInfo(x, z)
+			if err != nil {
+				if info.External {
+					problem(ProblemExternal, "%s", err)
+				} else {
+					problem(ProblemLength, "%s", err)
+				}
+				continue
+			}
+			if info.Compression < CompressionGzip || info.Compression > CompressionLZ4 {
+				problem(ProblemCompression, "type %d", byte(info.Compression))
+				continue
+			}
+			raw, err := r.rawChunk(x, z)
+			if err != nil {
+				problem(ProblemCorrupt, "%s", err)
+				continue
+			}
+			var data bytes.Buffer
+			if err := decompress(raw.compression, raw.data, &data); err != nil {
+				problem(ProblemCorrupt, "%s", err)
+				continue
+			}
+			cc.raw = raw
+			c, err := ParseChunk(data.Bytes(), r, x, z)
+			if err == nil {
+				_, err = c.Level()
+			}
+			if err != nil {
+				problem(ProblemNBT, "%s", err)
+				continue
+			}
+			cc.chunk = c
+			if px, pz, err := c.StoredPos(); err != nil {
+				problem(ProblemNBT, "%s", err)
+				cc.chunk = nil
+			} else if px != c.X() || pz != c.Z() {
+				problem(ProblemPosition, "says it is chunk %d,%d", px, pz)
+			}
+		}
+	}
+	return
+}
+
+// what Repair did to a region file
+type RepairResult struct {
+	Problems  []Problem
+	Dropped   int // unreadable chunks removed
+	Relocated int // chunks moved to the header slot they say they belong in
+}
+
+// rewrites region file fn keeping only the chunks which can be read.  A
+// readable chunk stored in the wrong slot is moved to the slot matching its
+// own coordinates if that's in this region and doesn't hold a readable chunk
+// already, otherwise it's dropped.  Rewriting the file also packs the
+// remaining chunks into fresh sectors, which fixes any overlaps.  The file
+// isn't touched if there are no problems
+func Repair(fn string) (result RepairResult, err error) {
+	r := new(Region)
+	if err = r.Open(fn); err != nil {
+		return
+	}
+	checks, err := r.check()
+	r.Close()
+	if err != nil {
+		return
+	}
+	w := &RegionWriter{X: r.X, Z: r.Z, filename: fn, Compression: CompressionZlib}
+	var misplaced []*chunkCheck
+	for i, cc := range checks {
+		if cc == nil {
+			continue
+		}
+		result.Problems = append(result.Problems, cc.problems...)
+		if !cc.readable() {
+			result.Dropped++
+			continue
+		}
+		if px, pz, _ := cc.chunk.StoredPos(); px != cc.chunk.X() || pz != cc.chunk.Z() {
+			misplaced = append(misplaced, cc)
+			continue
+		}
+		w.chunks[i] = cc.raw
+	}
+	for _, cc := range misplaced {
+		px, pz, _ := cc.chunk.StoredPos()
+		if px>>5 != r.X || pz>>5 != r.Z || w.HasChunk(px&31, pz&31) {
+			result.Dropped++
+			continue
+		}
+		w.chunks[header_offset(px&31, pz&31)/4] = cc.raw
+		result.Relocated++
+	}
+	if len(result.Problems) == 0 {
+		return
+	}
+	err = w.Save()
+	return
+}
@@ -0,0 +1,368 @@
+package mapper
+
+import "compress/gzip"
+import "container/list"
+import "errors"
+import "fmt"
+import "os"
+import "path/filepath"
+import "sort"
+import "strings"
+import "sync"
+
+import "github.com/timocp/nbt"
+
+var ChunkNotFoundError = errors.New("chunk not generated")
+
+// names of the vanilla dimensions
+const (
+	Overworld = "minecraft:overworld"
+	Nether    = "minecraft:the_nether"
+	End       = "minecraft:the_end"
+)
+
+// A World is a save directory: level.dat and a directory of region files for
+// each dimension
+type World struct {
+	Dir string
+	// the "Data" compound of level.dat
+	Level      nbt.CompoundTag
+	Info       *LevelInfo
+	Dimensions map[string]*Dimension // by namespaced name, eg Nether
+}
+
+// the region files of one dimension of a world.  Lookups are safe for
+// concurrent use
+type Dimension struct {
+	Name    string
+	Dir     string            // containing the r.X.Z.mca files
+	regions map[[2]int]string // filenames by region X Z
+	// regions and chunks which have been read, so that repeated lookups in
+	// the same area don't read the files again
+	mu     sync.Mutex
+	open   *lru // of *openRegion
+	chunks *lru // of *Chunk
+}
+
+// a region file a Dimension has open, which isn't closed while callers of
+// Region are using it, even once it's been pushed out of the cache
+type openRegion struct {
+	r       *Region
+	users   int
+	evicted bool
+}
+
+// closes the region if it's been pushed out of the cache and nobody is using
+// it.  Called with the Dimension locked
+func (o *openRegion) closeIfUnused() error {
+	if o.evicted && o.users == 0 {
+		return o.r.Close()
+	}
+	return nil
+}
+
+// the number of region files a Dimension keeps open, so that looking at a
+// whole world doesn't run out of file descriptors
+const dimensionRegionCache = 64
+
+// the number of chunks a Dimension keeps decoded
+const dimensionChunkCache = 64
+
+// keeps the max most recently used values, by region or chunk x z
+type lru struct {
+	max   int
+	order *list.List // of *lruEntry, most recently used first
+	items map[[2]int]*list.Element
+}
+
+type lruEntry struct {
+	key   [2]int
+	value interface{}
+}
+
+func newLRU(max int) *lru {
+	return &lru{max: max, order: list.New(), items: make(map[[2]int]*list.Element)}
+}
+
+func (c *lru) get(key [2]int) (interface{}, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry).value, true
+}
+
+// adds a value which isn't in the cache, returning the one it pushed out if
+// the cache was full
+func (c *lru) add(key [2]int, value interface{}) (evicted interface{}) {
+	if c.order.Len() >= c.max {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*lruEntry).key)
+		evicted = last.Value.(*lruEntry).value
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key, value})
+	return
+}
+
+// opens a save directory, finding region files for the overworld (region/),
+// the nether (DIM-1/region/), the end (DIM1/region/) and any custom
+// dimensions (dimensions/<namespace>/<name>/region/)
+func OpenWorld(dir string) (*World, error) {
+	w := &World{Dir: dir, Dimensions: make(map[string]*Dimension)}
+	level, err := readLevelDat(filepath.Join(dir, "level.dat"))
+	if err != nil {
+		return nil, err
+	}
+	if w.Level, err = compoundChild(level, "Data"); err != nil {
+		return nil, fmt.Errorf("level.dat: %s", err)
+	}
+	if w.Info, err = NewLevelInfo(w.Level); err != nil {
+		return nil, fmt.Errorf("level.dat: %s", err)
+	}
+	dirs := map[string]string{
+		Overworld: filepath.Join(dir, "region"),
+		Nether:    filepath.Join(dir, "DIM-1", "region"),
+		End:       filepath.Join(dir, "DIM1", "region"),
+	}
+	custom, _ := filepath.Glob(filepath.Join(dir, "dimensions", "*", "*", "region"))
+	for _, d := range custom {
+		name := filepath.Base(filepath.Dir(d))
+		namespace := filepath.Base(filepath.Dir(filepath.Dir(d)))
+		if _, ok := dirs[namespace+":"+name]; !ok {
+			dirs[namespace+":"+name] = d
+		}
+	}
+	for name, d := range dirs {
+		dim, err := openDimension(name, d)
+		if err != nil {
+			return nil, err
+		}
+		if dim != nil {
+			w.Dimensions[name] = dim
+		}
+	}
+	return w, nil
+}
+
+// returns nil if dir doesn't exist, eg the nether hasn't been visited
+func openDimension(name string, dir string) (*Dimension, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "r.*.*.mca"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return nil, nil
+		}
+	}
+	d := &Dimension{Name: name, Dir: dir, regions: make(map[[2]int]string)}
+	for _, fn := range files {
+		x, z, err := parseRegionFilename(fn)
+		if err != nil {
+			continue
+		}
+		d.regions[[2]int{x, z}] = fn
+	}
+	return d, nil
+}
+
+// reads a gzipped NBT file such as level.dat
+func readLevelDat(fn string) (root nbt.CompoundTag, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return root, fmt.Errorf("%s: %s", fn, err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %s", fn, &ParseError{r})
+		}
+	}()
+	tag := nbt.Parse(gz)
+	root, ok := tag.(nbt.CompoundTag)
+	if !ok {
+		err = fmt.Errorf("%s: %s", fn, tagTypeError("root", root, tag))
+	}
+	return
+}
+
+// returns the namespaced name of a dimension given either that or one of the
+// short names "overworld", "nether" or "end"
+func DimensionName(name string) string {
+	switch name {
+	case "overworld":
+		return Overworld
+	case "nether":
+		return Nether
+	case "end":
+		return End
+	}
+	if !strings.Contains(name, ":") {
+		return "minecraft:" + name
+	}
+	return name
+}
+
+// looks up a dimension by namespaced name, or one of the short names
+// accepted by DimensionName
+func (w *World) Dimension(name string) (*Dimension, error) {
+	name = DimensionName(name)
+	d, ok := w.Dimensions[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: no dimension %s", w.Dir, name)
+	}
+	return d, nil
+}
+
+// returns the block at world coordinates x y z in the overworld
+func (w *World) BlockAt(x int, y int, z int) (Block, error) {
+	d, err := w.Dimension(Overworld)
+	if err != nil {
+		return airBlock, err
+	}
+	return d.BlockAt(x, y, z)
+}
+
+// closes the region files opened by lookups
+func (w *World) Close() error {
+	var err error
+	for _, d := range w.Dimensions {
+		if cerr := d.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// the dimension's region files, sorted by name
+func (d *Dimension) RegionFiles() []string {
+	var files []string
+	for _, fn := range d.regions {
+		files = append(files, fn)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// returns the opened region file with region coordinates x z, or nil if
+// there isn't one.  It stays open, even once other lookups have pushed it
+// out of the cache, until release is called, which must be done once it's
+// finished with
+func (d *Dimension) Region(x int, z int) (r *Region, release func(), err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	o, err := d.region(x, z)
+	if o == nil {
+		return nil, func() {}, err
+	}
+	o.users++
+	var once sync.Once
+	return o.r, func() {
+		once.Do(func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			o.users--
+			o.closeIfUnused()
+		})
+	}, nil
+}
+
+// the region is only safe to use while d is locked
+func (d *Dimension) region(x int, z int) (*openRegion, error) {
+	key := [2]int{x, z}
+	if d.open == nil {
+		d.open = newLRU(dimensionRegionCache)
+	}
+	if o, ok := d.open.get(key); ok {
+		return o.(*openRegion), nil
+	}
+	fn, ok := d.regions[key]
+	if !ok {
+		return nil, nil
+	}
+	r := new(Region)
+	if err := r.Open(fn); err != nil {
+		return nil, err
+	}
+	o := &openRegion{r: r}
+	if old := d.open.add(key, o); old != nil {
+		old.(*openRegion).evicted = true
+		old.(*openRegion).closeIfUnused()
+	}
+	return o, nil
+}
+
+// returns the chunk with chunk coordinates x z, or ChunkNotFoundError if it
+// hasn't been generated.  The chunk is shared with later lookups, which is
+// safe as Chunks can be read from several goroutines at once
+func (d *Dimension) Chunk(x int, z int) (*Chunk, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.chunk(x, z)
+}
+
+func (d *Dimension) chunk(x int, z int) (*Chunk, error) {
+	key := [2]int{x, z}
+	if d.chunks == nil {
+		d.chunks = newLRU(dimensionChunkCache)
+	}
+	if c, ok := d.chunks.get(key); ok {
+		return c.(*Chunk), nil
+	}
+	o, err := d.region(x>>5, z>>5)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, ChunkNotFoundError
+	}
+	r := o.r
+	data, err := r.ChunkData(x&31, z&31)
+	if err != nil {
+		return nil, err
+	}
+	if data.Len() == 0 {
+		return nil, ChunkNotFoundError
+	}
+	c, err := ParseChunk(data.Bytes(), r, x&31, z&31)
+	if err != nil {
+		return nil, err
+	}
+	d.chunks.add(key, c)
+	return c, nil
+}
+
+// returns the block at world coordinates x y z
+func (d *Dimension) BlockAt(x int, y int, z int) (Block, error) {
+	c, err := d.Chunk(x>>4, z>>4)
+	if err != nil {
+		return airBlock, err
+	}
+	return c.BlockAt(x&15, y, z&15)
+}
+
+// closes the region files opened by lookups.  Those still in use from
+// Region are closed when they're released
+func (d *Dimension) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var err error
+	if d.open != nil {
+		for e := d.open.order.Front(); e != nil; e = e.Next() {
+			o := e.Value.(*lruEntry).value.(*openRegion)
+			o.evicted = true
+			if cerr := o.closeIfUnused(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	d.open = nil
+	d.chunks = nil
+	return err
+}
@@ -0,0 +1,107 @@
+package mapper
+
+import "bytes"
+import "encoding/binary"
+import "fmt"
+import "path/filepath"
+import "sync"
+import "testing"
+
+// the uncompressed NBT of a 1.18+ chunk at world chunk x z whose section 0
+// is all block, and which is air everywhere else
+func testSectionChunkNBT(x int, z int, block string) []byte {
+	var b bytes.Buffer
+	name := func(typ byte, s string) {
+		b.WriteByte(typ)
+		binary.Write(&b, binary.BigEndian, uint16(len(s)))
+		b.WriteString(s)
+	}
+	name(10, "")
+	for _, tag := range []struct {
+		name  string
+		value int32
+	}{{"DataVersion", 3465}, {"xPos", int32(x)}, {"zPos", int32(z)}, {"yPos", -4}} {
+		name(3, tag.name)
+		binary.Write(&b, binary.BigEndian, tag.value)
+	}
+	// sections: [{Y: 0, block_states: {palette: [{Name: block}]}}]
+	name(9, "sections")
+	b.WriteByte(10)
+	binary.Write(&b, binary.BigEndian, int32(1))
+	name(1, "Y")
+	b.WriteByte(0)
+	name(10, "block_states")
+	name(9, "palette")
+	b.WriteByte(10)
+	binary.Write(&b, binary.BigEndian, int32(1))
+	name(8, "Name")
+	binary.Write(&b, binary.BigEndian, uint16(len(block)))
+	b.WriteString(block)
+	b.WriteByte(0) // palette entry
+	b.WriteByte(0) // block_states
+	b.WriteByte(0) // section
+	b.WriteByte(0) // root
+	return b.Bytes()
+}
+
+// looks at the blocks of more regions than a Dimension keeps open from
+// several goroutines at once, while others hold regions from Region.  Run
+// with -race
+func TestDimensionConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	d := &Dimension{Name: Overworld, Dir: dir, regions: make(map[[2]int]string)}
+	regions := dimensionRegionCache + 6
+	for i := 0; i < regions; i++ {
+		fn := filepath.Join(dir, fmt.Sprintf("r.%d.0.mca", i))
+		w, err := NewRegionWriter(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.SetChunk(0, 0, testSectionChunkNBT(i*32, 0, "minecraft:stone")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Save(); err != nil {
+			t.Fatal(err)
+		}
+		d.regions[[2]int{i, 0}] = fn
+	}
+	defer d.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < regions; i++ {
+				// every goroutine looks at the same chunks, in a
+				// different order
+				rx := (i + g*7) % regions
+				if g%2 == 0 {
+					r, release, err := d.Region(rx, 0)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer release()
+					if data, err := r.ChunkData(0, 0); err != nil || data.Len() == 0 {
+						t.Errorf("region %d: %d bytes, %v", rx, data.Len(), err)
+					}
+				}
+				for _, y := range []int{0, 15, 16, -1} {
+					b, err := d.BlockAt(rx*512+g, y, g)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					want := "minecraft:air"
+					if y >= 0 && y < 16 {
+						want = "minecraft:stone"
+					}
+					if b.Name() != want {
+						t.Errorf("region %d y %d: %s, want %s", rx, y, b.Name(), want)
+					}
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
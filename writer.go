@@ -0,0 +1,187 @@
+package mapper
+
+import "bytes"
+import "compress/gzip"
+import "compress/zlib"
+import "encoding/binary"
+import "fmt"
+import "os"
+import "path/filepath"
+import "time"
+
+// A RegionWriter builds the contents of a region file in memory, starting
+// from the chunks of an existing file if there is one, and writes it out
+// with Save.  Chunks are kept compressed, so ones which aren't changed are
+// written back exactly as they were read.
+type RegionWriter struct {
+	X        int
+	Z        int
+	filename string
+	chunks   [1024]*rawChunk // by header_offset/4
+	// compression used by SetChunk; zlib (what the game writes) by default
+	Compression Compression
+	// .mcc files which should be removed once the region is saved
+	staleExternal []string
+}
+
+func NewRegionWriter(fn string) (*RegionWriter, error) {
+	x, z, err := parseRegionFilename(fn)
+	if err != nil {
+		return nil, err
+	}
+	w := &RegionWriter{X: x, Z: z, filename: fn, Compression: CompressionZlib}
+	if _, err := os.Stat(fn); os.IsNotExist(err) {
+		return w, nil
+	}
+	r := new(Region)
+	if err := r.Open(fn); err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			raw, err := r.rawChunk(x, z)
+			if err != nil {
+				return nil, err
+			}
+			w.chunks[header_offset(x, z)/4] = raw
+		}
+	}
+	return w, nil
+}
+
+// true if the region has a chunk at x z (0-31)
+func (w *RegionWriter) HasChunk(x int, z int) bool {
+	return w.chunks[header_offset(x, z)/4] != nil
+}
+
+// stores uncompressed NBT data (the form returned by Region.ChunkData) as the
+// chunk at x z, timestamped now
+func (w *RegionWriter) SetChunk(x int, z int, data []byte) error {
+	var compressed bytes.Buffer
+	if err := compress(w.Compression, data, &compressed); err != nil {
+		return err
+	}
+	w.forget(x, z)
+	w.chunks[header_offset(x, z)/4] = &rawChunk{w.Compression, compressed.Bytes(), time.Now(), false}
+	return nil
+}
+
+// removes the chunk at x z; the game will generate it again when it's next
+// visited
+func (w *RegionWriter) DeleteChunk(x int, z int) {
+	w.forget(x, z)
+	w.chunks[header_offset(x, z)/4] = nil
+}
+
+// notes that the existing chunk at x z is being replaced, so if it was in an
+// external file that will need removing
+func (w *RegionWriter) forget(x int, z int) {
+	if raw := w.chunks[header_offset(x, z)/4]; raw != nil && raw.external {
+		w.staleExternal = append(w.staleExternal, w.externalFilename(x, z))
+	}
+}
+
+func (w *RegionWriter) externalFilename(x int, z int) string {
+	return filepath.Join(filepath.Dir(w.filename), fmt.Sprintf("c.%d.%d.mcc", w.X*32+x, w.Z*32+z))
+}
+
+// writes the region to a temporary file beside the original and renames it
+// into place, so a failure part way leaves the original untouched.  Chunks are
+// packed in order from sector 2 with no free space between them
+func (w *RegionWriter) Save() error {
+	var header [8192]byte
+	var body bytes.Buffer
+	sector := 2
+	written := make(map[string]bool)
+	for z := 0; z < 32; z++ {
+		for x := 0; x < 32; x++ {
+			offset := header_offset(x, z)
+			raw := w.chunks[offset/4]
+			if raw == nil {
+				continue
+			}
+			compression := byte(raw.compression)
+			data := raw.data
+			if len(data)+5 > 255*4096 {
+				// too big for the location header to describe, so it goes
+				// in its own file
+				fn := w.externalFilename(x, z)
+				if err := writeFileAtomic(fn, data); err != nil {
+					return err
+				}
+				written[fn] = true
+				compression |= 0x80
+				data = nil
+			} else if raw.external {
+				w.staleExternal = append(w.staleExternal, w.externalFilename(x, z))
+			}
+			var chunk_header [5]byte
+			binary.BigEndian.PutUint32(chunk_header[0:4], uint32(len(data)+1))
+			chunk_header[4] = compression
+			body.Write(chunk_header[:])
+			body.Write(data)
+			sectors := (len(data) + 5 + 4095) / 4096
+			body.Write(make([]byte, sectors*4096-len(data)-5))
+			header[offset] = byte(sector >> 16)
+			header[offset+1] = byte(sector >> 8)
+			header[offset+2] = byte(sector)
+			header[offset+3] = byte(sectors)
+			binary.BigEndian.PutUint32(header[offset+4096:], uint32(raw.timestamp.Unix()))
+			sector += sectors
+		}
+	}
+	if err := writeFileAtomic(w.filename, append(header[:], body.Bytes()...)); err != nil {
+		return err
+	}
+	for _, fn := range w.staleExternal {
+		if !written[fn] {
+			os.Remove(fn)
+		}
+	}
+	w.staleExternal = nil
+	return nil
+}
+
+// writes data to a temporary file in the same directory as fn, then renames
+// it over fn
+func writeFileAtomic(fn string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(fn), filepath.Base(fn)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, fn)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func compress(compression Compression, data []byte, compressed *bytes.Buffer) error {
+	switch compression {
+	case CompressionGzip:
+		zw := gzip.NewWriter(compressed)
+		zw.Write(data)
+		return zw.Close()
+	case CompressionZlib:
+		zw := zlib.NewWriter(compressed)
+		zw.Write(data)
+		return zw.Close()
+	case CompressionNone:
+		compressed.Write(data)
+		return nil
+	}
+	return fmt.Errorf("compress: unsupported compression type %s", compression)
+}
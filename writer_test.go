@@ -0,0 +1,141 @@
+package mapper
+
+import "bytes"
+import "encoding/binary"
+import "math/rand"
+import "os"
+import "path/filepath"
+import "testing"
+import "time"
+
+// the uncompressed NBT of a 1.18+ chunk at world chunk x z, with pad bytes of
+// random (so incompressible) data
+func testChunkNBT(x int, z int, pad int) []byte {
+	var b bytes.Buffer
+	name := func(typ byte, s string) {
+		b.WriteByte(typ)
+		binary.Write(&b, binary.BigEndian, uint16(len(s)))
+		b.WriteString(s)
+	}
+	name(10, "")
+	for _, tag := range []struct {
+		name  string
+		value int32
+	}{{"DataVersion", 3465}, {"xPos", int32(x)}, {"zPos", int32(z)}} {
+		name(3, tag.name)
+		binary.Write(&b, binary.BigEndian, tag.value)
+	}
+	name(7, "pad")
+	binary.Write(&b, binary.BigEndian, int32(pad))
+	data := make([]byte, pad)
+	rand.New(rand.NewSource(int64(x*31 + z))).Read(data)
+	b.Write(data)
+	b.WriteByte(0)
+	return b.Bytes()
+}
+
+func TestRegionWriterRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "r.-1.2.mca")
+	w, err := NewRegionWriter(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// world chunk coordinates of x z within the region
+	world := func(x int, z int) (int, int) {
+		return -32 + x, 64 + z
+	}
+	chunks := map[[2]int][]byte{}
+	set := func(w *RegionWriter, x int, z int, pad int) {
+		wx, wz := world(x, z)
+		chunks[[2]int{x, z}] = testChunkNBT(wx, wz, pad)
+		if err := w.SetChunk(x, z, chunks[[2]int{x, z}]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set(w, 0, 0, 100)
+	set(w, 5, 7, 10000) // three sectors
+	set(w, 31, 31, 1200000)
+	before := time.Now().Add(-time.Second)
+	if err := w.Save(); err != nil {
+		t.Fatal(err)
+	}
+	mcc := filepath.Join(dir, "c.-1.95.mcc")
+	check := func(want int) {
+		t.Helper()
+		r := new(Region)
+		if err := r.Open(fn); err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		problems, err := r.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range problems {
+			t.Error(p)
+		}
+		infos, err := r.Chunks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(infos) != want {
+			t.Errorf("%d chunks, want %d", len(infos), want)
+		}
+		// packed from sector 2 in header order
+		next := 2
+		for _, info := range infos {
+			if info.Sector != next {
+				t.Errorf("chunk %d,%d at sector %d, want %d", info.X, info.Z, info.Sector, next)
+			}
+			next = info.Sector + info.Sectors
+			if info.Timestamp.Before(before) {
+				t.Errorf("chunk %d,%d timestamp %s", info.X, info.Z, info.Timestamp)
+			}
+			if info.External != (info.X == 31 && info.Z == 31) {
+				t.Errorf("chunk %d,%d external %t", info.X, info.Z, info.External)
+			}
+		}
+		if fi, err := os.Stat(fn); err != nil {
+			t.Error(err)
+		} else if fi.Size() != int64(next*4096) {
+			t.Errorf("file is %d bytes, want %d", fi.Size(), next*4096)
+		}
+		for x := 0; x < 32; x++ {
+			for z := 0; z < 32; z++ {
+				data, err := r.ChunkData(x, z)
+				if err != nil {
+					t.Fatalf("chunk %d,%d: %s", x, z, err)
+				}
+				if !bytes.Equal(data.Bytes(), chunks[[2]int{x, z}]) {
+					t.Errorf("chunk %d,%d: read %d bytes, want %d", x, z, data.Len(), len(chunks[[2]int{x, z}]))
+				}
+			}
+		}
+	}
+	check(3)
+	if _, err := os.Stat(mcc); err != nil {
+		t.Fatal(err)
+	}
+
+	// chunks which aren't changed are kept, deleted ones (including the
+	// oversized one and its file) go, and what's left is packed again
+	if w, err = NewRegionWriter(fn); err != nil {
+		t.Fatal(err)
+	}
+	if !w.HasChunk(31, 31) || w.HasChunk(1, 0) {
+		t.Error("HasChunk doesn't match the file")
+	}
+	w.DeleteChunk(5, 7)
+	w.DeleteChunk(31, 31)
+	delete(chunks, [2]int{5, 7})
+	delete(chunks, [2]int{31, 31})
+	set(w, 1, 0, 5000)
+	if err := w.Save(); err != nil {
+		t.Fatal(err)
+	}
+	check(2)
+	if _, err := os.Stat(mcc); !os.IsNotExist(err) {
+		t.Errorf("%s wasn't removed: %v", mcc, err)
+	}
+}